@@ -1,6 +1,7 @@
 package main
 
 import (
+  "fmt"
   "io/ioutil"
   "os"
   "os/user"
@@ -12,36 +13,40 @@ import (
 type FontSubscription struct {
   VersionPattern   `json:"version,omitempty" yaml:"version,omitempty"`
   Styles  []string `json:"repos,omitempty" yaml:"repos,omitempty"`
+  Aliases []string `json:"aliases,omitempty" yaml:"aliases,omitempty"`  // other family names
 }
 
 // similar type used only for YAML encoding
 type fontSubscription2 struct {
   Version *VersionPattern `yaml:"version"`
-  Styles []string         `yaml:"styles"`
+  Styles []string         `yaml:"styles,omitempty"`
+  Aliases []string        `yaml:"aliases,omitempty"`
 }
 
 func (p *FontSubscription) UnmarshalYAML(u func(interface{}) error) error {
   // handle two different forms:
   // - <string> -- e.g. ">=1.2"
-  // - { version?: v string, styles?: []string }
+  // - { version?: v string, styles?: []string, aliases?: []string }
   if err := p.VersionPattern.UnmarshalYAML(u); err != nil {
     st := fontSubscription2{ Version: &p.VersionPattern }
     if err := u(&st); err != nil {
       return err
     }
     p.Styles = st.Styles
+    p.Aliases = st.Aliases
   }
 
   return nil
 }
 
 func (p *FontSubscription) MarshalYAML() (interface{}, error) {
-  if p.Styles == nil || len(p.Styles) == 0 {
+  if len(p.Styles) == 0 && len(p.Aliases) == 0 {
     return p.VersionPattern, nil
   }
   return fontSubscription2{
     Version: &p.VersionPattern,
     Styles: p.Styles,
+    Aliases: p.Aliases,
   }, nil
 }
 
@@ -80,6 +85,31 @@ func (c *Config) FindFontIndex(fid string) *FontIndex {
   return nil
 }
 
+// FontFamilies returns the family names that installed files of the font fid
+// may have: the family of findex, followed by the aliases of the repo
+// version finfo (which may be nil) and of the subscription in c.
+// Aliases allow a family to be renamed without orphaning installed files.
+//
+func (c *Config) FontFamilies(fid string, findex *FontIndex, finfo *FontVersionInfo) []string {
+  families := []string{ findex.Family }
+  add := func(aliases []string) {
+    for _, alias := range aliases {
+      dup := len(alias) == 0
+      for _, family := range families {
+        dup = dup || family == alias
+      }
+      if !dup {
+        families = append(families, alias)
+      }
+    }
+  }
+  if finfo != nil {
+    add(finfo.Aliases)
+  }
+  add(c.Fonts[fid].Aliases)
+  return families
+}
+
 // InitDefault initializes a config struct to the state of the "built in"
 // configuration.
 //
@@ -179,6 +209,13 @@ func (c *Config) init2() {
     c.Repos[0] = &Repo{ Url: defaultRepoURL }
   }
 
+  // relative paths of local repos are relative to the config file
+  if len(c.File) > 0 && c.File != "<builtin>" {
+    for _, r := range c.Repos {
+      r.dir = filepath.Dir(c.File)
+    }
+  }
+
   // fontdir
   if len(c.FontDir) == 0 {
     c.FontDir = defaultFontDir()
@@ -192,4 +229,114 @@ func (c *Config) init2() {
     }
     c.FontDir = filepath.Clean(c.FontDir)
   }
+  // make fontdir absolute so that paths of font files, e.g. the keys of
+  // receipts, are always computed from the same base. Like the paths of
+  // local repos, a relative path is relative to the config file.
+  if !filepath.IsAbs(c.FontDir) && len(c.File) > 0 && c.File != "<builtin>" {
+    c.FontDir = filepath.Join(filepath.Dir(c.File), c.FontDir)
+  }
+  if dir, err := filepath.Abs(c.FontDir); err == nil {
+    c.FontDir = dir
+  }
+}
+
+
+// RemoveFont removes the subscription for the font identified by fid from
+// c.Fonts and from the config file c was loaded from. The file is edited
+// in place so that comments and formatting are preserved.
+//
+func (c *Config) RemoveFont(fid string) error {
+  if _, ok := c.Fonts[fid]; !ok {
+    return nil
+  }
+  delete(c.Fonts, fid)
+
+  if c.File == "<builtin>" {
+    return nil
+  }
+  data, err := ioutil.ReadFile(c.File)
+  if err != nil {
+    return err
+  }
+  data, ok := removeYAMLFontEntry(data, fid)
+  if !ok {
+    return fmt.Errorf(
+      "unable to find \"%s\" under \"fonts\" in %s; please remove it manually",
+      fid, c.File)
+  }
+
+  // make sure we didn't break the file
+  var c2 Config
+  if err := yaml.Unmarshal(data, &c2); err != nil {
+    return fmt.Errorf("failed to update %s: %v", c.File, err)
+  }
+  if _, ok := c2.Fonts[fid]; ok {
+    return fmt.Errorf(
+      "unable to remove \"%s\" from %s; please remove it manually", fid, c.File)
+  }
+
+  mode := os.FileMode(0644)
+  if st, err := os.Stat(c.File); err == nil {
+    mode = st.Mode().Perm()
+  }
+  return writeFileAtomic(c.File, data, mode)
+}
+
+
+// removeYAMLFontEntry removes the entry fid (including any nested lines)
+// from the top-level "fonts" mapping of a YAML document.
+// Returns false if no such entry was found.
+//
+func removeYAMLFontEntry(data []byte, fid string) ([]byte, bool) {
+  lines := strings.SplitAfter(string(data), "\n")
+  inFonts := false
+
+  for i, line := range lines {
+    s := strings.TrimSpace(line)
+    if len(s) == 0 || s[0] == '#' {
+      continue
+    }
+    indent := len(line) - len(strings.TrimLeft(line, " \t"))
+    if indent == 0 {
+      inFonts = strings.HasPrefix(s, "fonts:")
+      continue
+    }
+    if !inFonts || yamlKey(s) != fid {
+      continue
+    }
+
+    // found it; find the end of the entry
+    end := i + 1
+    for end < len(lines) {
+      s2 := strings.TrimSpace(lines[end])
+      indent2 := len(lines[end]) - len(strings.TrimLeft(lines[end], " \t"))
+      if len(s2) > 0 && s2[0] != '#' && indent2 <= indent {
+        break
+      }
+      end++
+    }
+    // leave trailing blank lines and comments alone
+    for end > i + 1 {
+      s2 := strings.TrimSpace(lines[end - 1])
+      if len(s2) > 0 && s2[0] != '#' {
+        break
+      }
+      end--
+    }
+
+    return []byte(strings.Join(lines[:i], "") + strings.Join(lines[end:], "")), true
+  }
+
+  return data, false
+}
+
+
+// yamlKey returns the key of a "key: value" line, without quotes
+//
+func yamlKey(s string) string {
+  p := strings.Index(s, ":")
+  if p == -1 {
+    return ""
+  }
+  return strings.Trim(strings.TrimSpace(s[:p]), "\"'")
 }
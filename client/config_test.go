@@ -0,0 +1,70 @@
+package main
+
+import "testing"
+
+func TestRemoveYAMLFontEntry(t *testing.T) {
+  input := `font-dir: ~/Library/Fonts/fontctrl
+repos:
+  - url: https://fontctrl.org/fonts/
+fonts:
+  # comment about inter-ui
+  inter-ui: ">=2.*"
+  "noto-sans":
+    version: ">=1"
+    # commented: out
+    styles: [ "bold" ]
+
+  # comment about roboto
+  roboto: "*"
+`
+  cases := [][]string{
+    []string{"inter-ui", `font-dir: ~/Library/Fonts/fontctrl
+repos:
+  - url: https://fontctrl.org/fonts/
+fonts:
+  # comment about inter-ui
+  "noto-sans":
+    version: ">=1"
+    # commented: out
+    styles: [ "bold" ]
+
+  # comment about roboto
+  roboto: "*"
+`},
+    []string{"noto-sans", `font-dir: ~/Library/Fonts/fontctrl
+repos:
+  - url: https://fontctrl.org/fonts/
+fonts:
+  # comment about inter-ui
+  inter-ui: ">=2.*"
+
+  # comment about roboto
+  roboto: "*"
+`},
+    []string{"roboto", `font-dir: ~/Library/Fonts/fontctrl
+repos:
+  - url: https://fontctrl.org/fonts/
+fonts:
+  # comment about inter-ui
+  inter-ui: ">=2.*"
+  "noto-sans":
+    version: ">=1"
+    # commented: out
+    styles: [ "bold" ]
+
+  # comment about roboto
+`},
+  }
+  for _, c := range cases {
+    actual, ok := removeYAMLFontEntry([]byte(input), c[0])
+    if !ok {
+      t.Errorf("(\"%s\") => not found", c[0])
+    } else if string(actual) != c[1] {
+      t.Errorf("(\"%s\") =>\n%s\nexpected:\n%s", c[0], actual, c[1])
+    }
+  }
+
+  if _, ok := removeYAMLFontEntry([]byte(input), "url"); ok {
+    t.Errorf("(\"url\") => found; expected only fonts to be searched")
+  }
+}
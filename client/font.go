@@ -1,11 +1,19 @@
 package main
 
 import (
+  "bytes"
   "errors"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "math"
   "os"
+  "path/filepath"
   "regexp"
-  // "strings"
+  "strconv"
+  "strings"
   "github.com/ConradIrwin/font/sfnt"
+  "github.com/rsms/fontctrl/client/woff"
 )
 
 type FontType int
@@ -13,27 +21,123 @@ type FontType int
 const (
   FontTypeTTF = FontType(iota)
   FontTypeOTF
+  FontTypeCollection  // .ttc or .otc file with several fonts
+  FontTypeWOFF
+  FontTypeWOFF2
+  FontTypeTTX  // fontTools XML
+)
+
+// FontVersionSource identifies where the version of a FontFile was read from
+type FontVersionSource string
+
+const (
+  VersionFromName = FontVersionSource("name")  // version record of name table
+  VersionFromHead = FontVersionSource("head")  // head.fontRevision
 )
 
 type FontFile struct {
-  Family  string   `json:"family"`
-  Style   string   `json:"style"`
-  Version Version  `json:"version"`
-  FontID  string   `json:"uid"`  // == fontId record of name table
+  Filename      string            `json:"filename"`
+  Family        string            `json:"family"`
+  Style         string            `json:"style"`
+  Version       Version           `json:"version"`
+  VersionSource FontVersionSource `json:"version_source"`
+  FontID        string            `json:"uid"`  // == fontId record of name table
+  Face          int               `json:"face,omitempty"`  // index in collection
+  Families      []FontName        `json:"families,omitempty"`  // localized
+  Styles        []FontName        `json:"styles,omitempty"`  // localized
 }
 
+// FontName is a family or style name of a font in a specific language
+type FontName struct {
+  Platform uint16 `json:"platform"`  // name table platform ID
+  Language uint16 `json:"lang"`      // platform-specific language ID
+  Value    string `json:"value"`
+}
+
+// name table platform and language IDs
+const (
+  platformUnicode = 0
+  platformMac     = 1
+  platformWindows = 3
+
+  langMacEnglish  = 0
+  langWindowsEnUS = 0x0409
+  langWindowsEn   = 0x09  // primary language of any English variant
+)
+
 
 var extToFontType map[string]FontType
-var uidRegExpEnd, uidRegExpAny *regexp.Regexp
+var uidRegExpEnd, uidRegExpAny, nameVersionNumRegExp *regexp.Regexp
 
 func init() {
   extToFontType = make(map[string]FontType)
   extToFontType[".ttf"] = FontTypeTTF
-  extToFontType[".ttx"] = FontTypeTTF
+  extToFontType[".ttx"] = FontTypeTTX
   extToFontType[".otf"] = FontTypeOTF
+  extToFontType[".ttc"] = FontTypeCollection
+  extToFontType[".otc"] = FontTypeCollection
+  extToFontType[".woff"] = FontTypeWOFF
+  extToFontType[".woff2"] = FontTypeWOFF2
 
   uidRegExpEnd = regexp.MustCompile(`(?i)\b([A-Fa-f0-9][A-Fa-f0-9\-.]*)\s*$`)
   uidRegExpAny = regexp.MustCompile(`(?i)\b([A-Fa-f0-9][A-Fa-f0-9\-.]*)\b`)
+  nameVersionNumRegExp = regexp.MustCompile(`\d+(?:\.\d+)?`)
+}
+
+
+// ParseFontFile parses the font file filename. Returns one FontFile for each
+// face when filename is a font collection.
+//
+func ParseFontFile(filename string) ([]*FontFile, error) {
+  if extToFontType[strings.ToLower(filepath.Ext(filename))] == FontTypeTTX {
+    return parseTTXFile(filename)
+  }
+
+  fp, err := os.Open(filename)
+  if err != nil {
+    return nil, err
+  }
+  defer fp.Close()
+
+  var file sfnt.File = fp
+  var sig [4]byte
+  if _, err := fp.ReadAt(sig[:], 0); err == nil && woff.IsWOFF(sig[:]) {
+    // web fonts are compressed as a whole; decode into memory
+    data, err := ioutil.ReadAll(fp)
+    if err == nil {
+      data, err = woff.Decode(data)
+    }
+    if err != nil {
+      return nil, err
+    }
+    file = bytes.NewReader(data)
+  }
+
+  offsets, err := readSfntCollection(file)
+  if err != nil {
+    return nil, err
+  }
+  if offsets == nil {
+    f := &FontFile{ Filename: filename }
+    if err := f.Parse(file); err != nil {
+      return nil, err
+    }
+    return []*FontFile{ f }, nil
+  }
+
+  fonts := make([]*FontFile, len(offsets))
+  for i, offset := range offsets {
+    info, err := readSfntFontInfo(file, offset)
+    if err != nil {
+      return nil, fmt.Errorf("face %d: %v", i, err)
+    }
+    f := &FontFile{ Filename: filename, Face: i }
+    if err := f.setInfo(info); err != nil {
+      return nil, fmt.Errorf("face %d: %v", i, err)
+    }
+    fonts[i] = f
+  }
+  return fonts, nil
 }
 
 
@@ -43,66 +147,85 @@ func (f *FontFile) ParseFile(filename string) error {
     return err
   }
   defer fp.Close()
+  f.Filename = filename
   return f.Parse(fp)
 }
 
 
 func (f *FontFile) Parse(file sfnt.File) error {
-  font, err := sfnt.Parse(file)
+  // fast path: read just the name and head tables
+  info, err := readSfntFontInfo(file, 0)
   if err != nil {
-    return err
+    // not a plain sfnt font (e.g. WOFF); let sfnt parse the whole thing
+    if _, err := file.Seek(0, io.SeekStart); err != nil {
+      return err
+    }
+    if info, err = parseSfntFontInfo(file); err != nil {
+      return err
+    }
   }
+  return f.setInfo(info)
+}
+
 
+// parseSfntFontInfo reads info by parsing the entire font
+func parseSfntFontInfo(file sfnt.File) (*sfntFontInfo, error) {
+  font, err := sfnt.Parse(file)
+  if err != nil {
+    return nil, err
+  }
   namet := font.NameTable()
   if namet == nil {
-    return errors.New("missing name table")
+    return nil, errors.New("missing name table")
   }
-
-  var version, uid string
-
+  info := &sfntFontInfo{}
   for _, ent := range namet.List() {
-    switch ent.NameID {
-    
-    case sfnt.NamePreferredFamily:
-      if len(f.Family) == 0 {
-        // L.Printf("- family: %s\n", ent.String())
-        f.Family = ent.String()
-      }
-
-    case sfnt.NamePreferredSubfamily:
-      if len(f.Style) == 0 {
-        // L.Printf("- style: %s\n", ent.String())
-        f.Style = ent.String()
-      }
-
-    case sfnt.NameVersion:
-      version = ent.String()
+    info.Names = append(info.Names, sfntName{
+      PlatformID: uint16(ent.PlatformID),
+      EncodingID: uint16(ent.EncodingID),
+      LanguageID: uint16(ent.LanguageID),
+      NameID:     ent.NameID,
+      Value:      ent.String(),
+    })
+  }
+  // sfnt.File is an io.ReaderAt, but only plain sfnt fonts have a table
+  // directory at offset 0
+  info.Revision, err = readHeadFontRevision(file, 0)
+  info.HasRevision = err == nil
+  return info, nil
+}
 
-    case sfnt.NameUniqueIdentifier:
-      uid = ent.String()
 
-    }
+func (f *FontFile) setInfo(info *sfntFontInfo) error {
+  // typoPreferredFamily and typoPreferredSubfamily name the family as a
+  // whole, where the legacy family names group at most four styles
+  familyID, styleID := sfnt.NamePreferredFamily, sfnt.NamePreferredSubfamily
+  if len(findFontName(info.Names, familyID)) == 0 {
+    // font is missing typoPreferredFamily
+    familyID = sfnt.NameFontFamily
+  }
+  if len(findFontName(info.Names, styleID)) == 0 {
+    // font is missing typoPreferredSubfamily
+    styleID = sfnt.NameFontSubfamily
   }
 
+  f.Family = findFontName(info.Names, familyID)
   if len(f.Family) == 0 {
-    // maybe font is missing typoPreferredFamily
-    f.Family = findFontNameValue(namet, sfnt.NameFontFamily)
-    if len(f.Family) == 0 {
-      return errors.New("no family name")
-    }
+    return errors.New("no family name")
   }
-
+  f.Style = findFontName(info.Names, styleID)
   if len(f.Style) == 0 {
-    // maybe font is missing typoPreferredSubfamily
-    f.Style = findFontNameValue(namet, sfnt.NameFontSubfamily)
-    if len(f.Style) == 0 {
-      return errors.New("no subfamily/style name")
-    }
+    return errors.New("no subfamily/style name")
   }
+  f.Families = localizedFontNames(info.Names, familyID)
+  f.Styles = localizedFontNames(info.Names, styleID)
 
-  // parse version
-  err = parseFontVersion(version, uid, &f.Version)
-  if err != nil {
+  version := findFontName(info.Names, sfnt.NameVersion)
+  uid := findFontName(info.Names, sfnt.NameUniqueIdentifier)
+
+  // parse version, using head.fontRevision when the name table's version
+  // string is missing or can't be trusted
+  if err := f.setVersion(version, uid, info.Revision, info.HasRevision); err != nil {
     return err
   }
 
@@ -116,13 +239,166 @@ func (f *FontFile) Parse(file sfnt.File) error {
 }
 
 
-func findFontNameValue(namet *sfnt.TableName, nameID sfnt.NameID) string {
-  for _, ent := range namet.List() {
-    if ent.NameID == nameID {
-      return ent.String()
+// HasFamily returns true if family is any of the localized family names of f
+//
+func (f *FontFile) HasFamily(family string) bool {
+  if f.Family == family {
+    return true
+  }
+  for _, name := range f.FamilyNames() {
+    if name == family {
+      return true
+    }
+  }
+  return false
+}
+
+
+// FamilyNames returns the values of f.Families
+//
+func (f *FontFile) FamilyNames() []string {
+  names := make([]string, len(f.Families))
+  for i, n := range f.Families {
+    names[i] = n.Value
+  }
+  return names
+}
+
+
+// findFontName returns the value of the name record nameID which is most
+// preferred by fontNameRank, or "" if there is none
+//
+func findFontName(names []sfntName, nameID sfnt.NameID) string {
+  best, bestRank := "", -1
+  for _, ent := range names {
+    if ent.NameID != nameID || len(ent.Value) == 0 {
+      continue
+    }
+    if rank := fontNameRank(ent); bestRank == -1 || rank < bestRank {
+      best, bestRank = ent.Value, rank
+    }
+  }
+  return best
+}
+
+
+// fontNameRank ranks name records by platform and language, lower being
+// more preferred:
+//
+//   0  Windows, English (United States)
+//   1  Macintosh, English
+//   2  Windows, any other English
+//   3  Unicode (no language)
+//   4  any other Windows language
+//   5  any other Macintosh language
+//   6  anything else
+//
+// Records of the same rank are preferred in name table order.
+//
+func fontNameRank(ent sfntName) int {
+  switch ent.PlatformID {
+  case platformWindows:
+    switch {
+      case ent.LanguageID == langWindowsEnUS:       return 0
+      case ent.LanguageID & 0x3ff == langWindowsEn: return 2
+      default:                                      return 4
+    }
+  case platformMac:
+    if ent.LanguageID == langMacEnglish {
+      return 1
+    }
+    return 5
+  case platformUnicode:
+    return 3
+  }
+  return 6
+}
+
+
+// localizedFontNames returns the distinct values of name record nameID on
+// each platform and language
+//
+func localizedFontNames(names []sfntName, nameID sfnt.NameID) []FontName {
+  var v []FontName
+  for _, ent := range names {
+    if ent.NameID != nameID || len(ent.Value) == 0 {
+      continue
+    }
+    n := FontName{ Platform: ent.PlatformID, Language: ent.LanguageID, Value: ent.Value }
+    dup := false
+    for _, n2 := range v {
+      dup = dup || n2 == n
     }
+    if !dup {
+      v = append(v, n)
+    }
+  }
+  return v
+}
+
+
+// setVersion reconciles the version string of the name table with the
+// font revision of the head table. The name table's version is used when it
+// can be parsed, since it may carry build metadata, with a warning if it
+// disagrees with the head table. Otherwise head.fontRevision is used, which
+// is always a plain number.
+//
+func (f *FontFile) setVersion(name, uid string, rev Fixed, hasRev bool) error {
+  if hasRev && rev.Float() < 0 {
+    hasRev = false  // nonsense; ignore
   }
-  return ""
+
+  var nv Version
+  nameErr := parseFontVersion(name, uid, &nv)
+  if nameErr == nil {
+    f.Version = nv
+    f.VersionSource = VersionFromName
+    if hasRev && !nameVersionAgrees(name, rev) {
+      L.Printf("warning: %s: name table version \"%s\" disagrees with" +
+        " head.fontRevision %s; using %s", f.Filename, name,
+        fontRevisionString(rev), nv.String())
+    }
+    return nil
+  }
+  if !hasRev {
+    return nameErr
+  }
+
+  hv := fontRevisionString(rev)
+  if err := parseFontVersion(hv, uid, &f.Version); err != nil {
+    return err
+  }
+  f.VersionSource = VersionFromHead
+  if len(strings.TrimSpace(name)) > 0 {
+    L.Printf("warning: %s: unable to parse name table version \"%s\";" +
+      " using head.fontRevision %s", f.Filename, name, hv)
+  }
+  return nil
+}
+
+
+// nameVersionAgrees returns true if the first number in the name table's
+// version string (e.g. "3.19" in "Version 3.19;git-abc") is equal to rev.
+// Font tools round fontRevision to three decimals, so we do too.
+//
+func nameVersionAgrees(name string, rev Fixed) bool {
+  s := nameVersionNumRegExp.FindString(name)
+  if len(s) == 0 {
+    return false
+  }
+  n, err := strconv.ParseFloat(s, 64)
+  if err != nil {
+    return false
+  }
+  return math.Abs(n - rev.Float()) < 0.0005
+}
+
+
+// fontRevisionString formats rev with at most three decimals, e.g. "3.19"
+func fontRevisionString(rev Fixed) string {
+  s := strconv.FormatFloat(math.Round(rev.Float() * 1000) / 1000, 'f', 3, 64)
+  s = strings.TrimRight(s, "0")
+  return strings.TrimSuffix(s, ".")
 }
 
 
@@ -0,0 +1,486 @@
+package main
+
+import (
+  "bytes"
+  "encoding/binary"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "sort"
+  "testing"
+  "unicode/utf16"
+
+  "github.com/ConradIrwin/font/sfnt"
+)
+
+// makeTestSfnt returns a minimal sfnt font file containing tables
+func makeTestSfnt(tables map[string][]byte) []byte {
+  return makeTestSfntAt(0, tables)
+}
+
+// makeTestSfntAt returns a font to be placed at offset base in a file
+func makeTestSfntAt(base int, tables map[string][]byte) []byte {
+  tags := make([]string, 0, len(tables))
+  for tag := range tables {
+    tags = append(tags, tag)
+  }
+  sort.Strings(tags)
+
+  var hdr, data bytes.Buffer
+  hdr.Write([]byte{0, 1, 0, 0})
+  binary.Write(&hdr, binary.BigEndian, uint16(len(tags)))
+  hdr.Write(make([]byte, 6))  // searchRange, entrySelector, rangeShift
+  offset := base + 12 + 16 * len(tags)
+  for _, tag := range tags {
+    b := tables[tag]
+    hdr.WriteString(tag)
+    binary.Write(&hdr, binary.BigEndian, uint32(0))  // checksum
+    binary.Write(&hdr, binary.BigEndian, uint32(offset + data.Len()))
+    binary.Write(&hdr, binary.BigEndian, uint32(len(b)))
+    data.Write(b)
+    for data.Len() % 4 != 0 {
+      data.WriteByte(0)
+    }
+  }
+  return append(hdr.Bytes(), data.Bytes()...)
+}
+
+// makeTestCollection returns a font collection (.ttc) of fonts
+func makeTestCollection(fonts ...map[string][]byte) []byte {
+  var b bytes.Buffer
+  b.WriteString("ttcf")
+  binary.Write(&b, binary.BigEndian, uint32(0x00010000))  // version 1.0
+  binary.Write(&b, binary.BigEndian, uint32(len(fonts)))
+  offset := 12 + 4 * len(fonts)
+  var data []byte
+  for _, tables := range fonts {
+    binary.Write(&b, binary.BigEndian, uint32(offset + len(data)))
+    data = append(data, makeTestSfntAt(offset + len(data), tables)...)
+  }
+  return append(b.Bytes(), data...)
+}
+
+// makeTestHead returns a head table with fontRevision rev
+func makeTestHead(rev float64) []byte {
+  b := make([]byte, 54)
+  binary.BigEndian.PutUint16(b[0:], 1)
+  binary.BigEndian.PutUint32(b[4:], uint32(int32(rev * 65536 + 0.5)))
+  return b
+}
+
+// makeTestName returns a name table with Windows (platform 3) records for
+// each name, plus Macintosh (platform 1) records for macNames
+func makeTestName(names, macNames map[sfnt.NameID]string) []byte {
+  type rec struct {
+    platformID uint16
+    nameID     sfnt.NameID
+    value      []byte
+  }
+  var recs []rec
+  for id, s := range macNames {
+    var b []byte
+    for _, r := range s {
+      if r < 0x80 {
+        b = append(b, byte(r))
+      } else {
+        for i, r2 := range macRomanHigh {
+          if r2 == r {
+            b = append(b, byte(0x80 + i))
+          }
+        }
+      }
+    }
+    recs = append(recs, rec{ 1, id, b })
+  }
+  for id, s := range names {
+    u := utf16.Encode([]rune(s))
+    b := make([]byte, len(u) * 2)
+    for i, c := range u {
+      binary.BigEndian.PutUint16(b[i * 2:], c)
+    }
+    recs = append(recs, rec{ 3, id, b })
+  }
+  sort.Slice(recs, func(i, j int) bool {
+    if recs[i].platformID != recs[j].platformID {
+      return recs[i].platformID < recs[j].platformID
+    }
+    return recs[i].nameID < recs[j].nameID
+  })
+
+  var hdr, storage bytes.Buffer
+  binary.Write(&hdr, binary.BigEndian, uint16(0))  // format
+  binary.Write(&hdr, binary.BigEndian, uint16(len(recs)))
+  binary.Write(&hdr, binary.BigEndian, uint16(6 + 12 * len(recs)))
+  for _, r := range recs {
+    encodingID, languageID := uint16(1), uint16(0x409)  // Unicode BMP, en-US
+    if r.platformID == 1 {
+      encodingID, languageID = 0, 0  // Roman, English
+    }
+    for _, v := range []uint16{
+      r.platformID, encodingID, languageID, uint16(r.nameID),
+      uint16(len(r.value)), uint16(storage.Len()),
+    } {
+      binary.Write(&hdr, binary.BigEndian, v)
+    }
+    storage.Write(r.value)
+  }
+  return append(hdr.Bytes(), storage.Bytes()...)
+}
+
+
+func TestReadHeadFontRevision(t *testing.T) {
+  data := makeTestSfnt(map[string][]byte{
+    "head": makeTestHead(3.19),
+    "name": make([]byte, 6),
+  })
+  rev, err := readHeadFontRevision(bytes.NewReader(data), 0)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if s := fontRevisionString(rev); s != "3.19" {
+    t.Errorf("fontRevision = %s ; expected 3.19", s)
+  }
+
+  data = makeTestSfnt(map[string][]byte{ "name": make([]byte, 6) })
+  if _, err := readHeadFontRevision(bytes.NewReader(data), 0); err == nil {
+    t.Errorf("expected error for font without head table")
+  }
+  if _, err := readHeadFontRevision(bytes.NewReader([]byte("wOFF....")), 0); err == nil {
+    t.Errorf("expected error for non-sfnt data")
+  }
+}
+
+
+func TestReadSfntTableBounds(t *testing.T) {
+  font := func(tag string, length uint32) []byte {
+    data := makeTestSfnt(map[string][]byte{
+      "head": makeTestHead(3.19),
+      "name": make([]byte, 6),
+    })
+    i := 0  // record index; tags are sorted
+    if tag == "name" {
+      i = 1
+    }
+    binary.BigEndian.PutUint32(data[12 + i * 16 + 12:], length)
+    return data
+  }
+  for _, length := range []uint32{ 0xfffffff0, maxNameTableLength + 1, 1000 } {
+    data := font("name", length)
+    if _, err := readSfntFontInfo(bytes.NewReader(data), 0); err == nil {
+      t.Errorf("expected error for name table of length %d", length)
+    }
+  }
+  for _, length := range []uint32{ 0xfffffff0, headTableLength + 1 } {
+    data := font("head", length)
+    if _, err := readHeadFontRevision(bytes.NewReader(data), 0); err == nil {
+      t.Errorf("expected error for head table of length %d", length)
+    }
+  }
+}
+
+
+func TestFontFileSetVersion(t *testing.T) {
+  fixed := func(f float64) Fixed { return Fixed(int32(f * 65536 + 0.5)) }
+  tests := []struct {
+    name    string
+    rev     float64  // < 0 means no head table
+    version string
+    source  FontVersionSource
+  }{
+    { "Version 3.019", 3.019, "3.19.0", VersionFromName },
+    { "Version 1.06", 1.06, "1.6.0", VersionFromName },
+    { "Version 1.000", 1, "1.0.0", VersionFromName },
+    { "Version 3.19;git-abc", 3.19, "3.19.0+git-abc", VersionFromName },
+    { "Version 2.1", -1, "2.1.0", VersionFromName },
+    // name table versions which disagree with head are still used
+    { "Version 3.1 (2.900)", 2.9, "3.1.0+2.900", VersionFromName },
+    { "Version 2.0;git-abc", 1.9, "2.0.0+git-abc", VersionFromName },
+    // unusable name table versions
+    { "Release candidate", 1.5, "1.5.0", VersionFromHead },
+    { "", 2.003, "2.3.0", VersionFromHead },
+  }
+  for _, test := range tests {
+    f := &FontFile{ Filename: "test.otf" }
+    err := f.setVersion(test.name, "", fixed(test.rev), test.rev >= 0)
+    if err != nil {
+      t.Errorf("%q: %v", test.name, err)
+      continue
+    }
+    if s := f.Version.String(); s != test.version {
+      t.Errorf("%q: version = %s ; expected %s", test.name, s, test.version)
+    }
+    if f.VersionSource != test.source {
+      t.Errorf("%q: source = %s ; expected %s",
+        test.name, f.VersionSource, test.source)
+    }
+  }
+
+  f := &FontFile{}
+  if err := f.setVersion("", "", 0, false); err == nil {
+    t.Errorf("expected error without any version")
+  }
+}
+
+
+func TestFontFileParse(t *testing.T) {
+  tmpdir, err := ioutil.TempDir("", "fontctrl-test")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(tmpdir)
+
+  filename := filepath.Join(tmpdir, "Cafe-Regular.otf")
+  data := makeTestSfnt(map[string][]byte{
+    "head": makeTestHead(1.5),
+    "name": makeTestName(map[sfnt.NameID]string{
+      sfnt.NameFontFamily:         "Café",
+      sfnt.NameFontSubfamily:      "Regular",
+      sfnt.NameVersion:            "Version 1.500",
+      sfnt.NameUniqueIdentifier:   "1.500;CAFE;Cafe-Regular;a1b2c3",
+    }, map[sfnt.NameID]string{
+      sfnt.NamePreferredFamily:    "Café Text",
+    }),
+    "glyf": make([]byte, 4096),
+  })
+  if err := ioutil.WriteFile(filename, data, 0644); err != nil {
+    t.Fatal(err)
+  }
+
+  f := &FontFile{}
+  if err := f.ParseFile(filename); err != nil {
+    t.Fatal(err)
+  }
+  if f.Family != "Café Text" || f.Style != "Regular" {
+    t.Errorf("family, style = %q, %q ; expected \"Café Text\", \"Regular\"",
+      f.Family, f.Style)
+  }
+  if s := f.Version.String(); s != "1.500.0+a1b2c3" {
+    t.Errorf("version = %s ; expected 1.500.0+a1b2c3", s)
+  }
+  if f.VersionSource != VersionFromName {
+    t.Errorf("version source = %s ; expected %s", f.VersionSource, VersionFromName)
+  }
+
+  // missing name table
+  data = makeTestSfnt(map[string][]byte{ "head": makeTestHead(1) })
+  if err := ioutil.WriteFile(filename, data, 0644); err != nil {
+    t.Fatal(err)
+  }
+  if err := (&FontFile{}).ParseFile(filename); err == nil {
+    t.Errorf("expected error for font without name table")
+  }
+}
+
+
+func TestFontFileLocalizedNames(t *testing.T) {
+  name := func(platformID, languageID uint16, id sfnt.NameID, value string) sfntName {
+    return sfntName{
+      PlatformID: platformID, LanguageID: languageID, NameID: id, Value: value,
+    }
+  }
+  const ja, enGB, de = 0x0411, 0x0809, 0x0407
+  tests := []struct {
+    names    []sfntName
+    family   string
+    style    string
+    families int  // number of localized family names
+  }{
+    // Windows en-US is preferred over names listed before it
+    { []sfntName{
+        name(3, ja, sfnt.NamePreferredFamily, "源ノ角ゴシック"),
+        name(3, ja, sfnt.NamePreferredSubfamily, "太字"),
+        name(3, 0x409, sfnt.NamePreferredFamily, "Source Han Sans"),
+        name(3, 0x409, sfnt.NamePreferredSubfamily, "Bold"),
+      }, "Source Han Sans", "Bold", 2 },
+    // Macintosh English before other English variants
+    { []sfntName{
+        name(3, enGB, sfnt.NameFontFamily, "Colour Sans"),
+        name(1, 0, sfnt.NameFontFamily, "Color Sans"),
+        name(3, de, sfnt.NameFontSubfamily, "Fett"),
+        name(3, enGB, sfnt.NameFontSubfamily, "Bold"),
+      }, "Color Sans", "Bold", 2 },
+    // Unicode platform before other languages
+    { []sfntName{
+        name(3, de, sfnt.NameFontFamily, "Schrift"),
+        name(0, 0, sfnt.NameFontFamily, "Type"),
+        name(3, de, sfnt.NameFontSubfamily, "Fett"),
+      }, "Type", "Fett", 2 },
+    // preferred family in any language over legacy family in English
+    { []sfntName{
+        name(3, 0x409, sfnt.NameFontFamily, "Noto Sans JP Bold"),
+        name(3, ja, sfnt.NamePreferredFamily, "Noto Sans JP"),
+        name(3, 0x409, sfnt.NameFontSubfamily, "Bold"),
+      }, "Noto Sans JP", "Bold", 1 },
+    // duplicates are listed once
+    { []sfntName{
+        name(3, 0x409, sfnt.NameFontFamily, "Inter"),
+        name(3, 0x409, sfnt.NameFontFamily, "Inter"),
+        name(3, 0x409, sfnt.NameFontSubfamily, "Bold"),
+      }, "Inter", "Bold", 1 },
+  }
+  version := name(3, 0x409, sfnt.NameVersion, "Version 1.0")
+  for i, test := range tests {
+    f := &FontFile{}
+    names := append([]sfntName{ version }, test.names...)
+    if err := f.setInfo(&sfntFontInfo{ Names: names }); err != nil {
+      t.Errorf("#%d: %v", i, err)
+      continue
+    }
+    if f.Family != test.family || f.Style != test.style {
+      t.Errorf("#%d: family, style = %q, %q ; expected %q, %q",
+        i, f.Family, f.Style, test.family, test.style)
+    }
+    if len(f.Families) != test.families {
+      t.Errorf("#%d: got %d localized family names; expected %d",
+        i, len(f.Families), test.families)
+    }
+    for _, n := range f.Families {
+      if !f.HasFamily(n.Value) {
+        t.Errorf("#%d: HasFamily(%q) = false", i, n.Value)
+      }
+    }
+  }
+
+  // the index finds fonts by any of their family names
+  f := &FontFile{ Filename: "SourceHanSans-Bold.otf" }
+  names := append([]sfntName{ version }, tests[0].names...)
+  if err := f.setInfo(&sfntFontInfo{ Names: names }); err != nil {
+    t.Fatal(err)
+  }
+  local := NewLocalFontIndex(nil)
+  local.addFont(f)
+  for _, family := range []string{ "Source Han Sans", "源ノ角ゴシック" } {
+    if n := len(local.FindFamily(family)); n != 1 {
+      t.Errorf("FindFamily(%q) found %d fonts; expected 1", family, n)
+    }
+  }
+}
+
+
+func TestParseFontCollection(t *testing.T) {
+  tmpdir, err := ioutil.TempDir("", "fontctrl-test")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(tmpdir)
+
+  face := func(family, style string) map[string][]byte {
+    return map[string][]byte{
+      "head": makeTestHead(2.004),
+      "name": makeTestName(map[sfnt.NameID]string{
+        sfnt.NameFontFamily:    family,
+        sfnt.NameFontSubfamily: style,
+        sfnt.NameVersion:       "Version 2.004",
+      }, nil),
+    }
+  }
+  filename := filepath.Join(tmpdir, "NotoSansCJK-Regular.ttc")
+  data := makeTestCollection(
+    face("Noto Sans CJK JP", "Regular"),
+    face("Noto Sans CJK KR", "Regular"),
+    face("Noto Sans Mono CJK JP", "Regular"),
+  )
+  if err := ioutil.WriteFile(filename, data, 0644); err != nil {
+    t.Fatal(err)
+  }
+
+  fonts, err := ParseFontFile(filename)
+  if err != nil {
+    t.Fatal(err)
+  }
+  expected := []string{ "Noto Sans CJK JP", "Noto Sans CJK KR", "Noto Sans Mono CJK JP" }
+  if len(fonts) != len(expected) {
+    t.Fatalf("got %d faces; expected %d", len(fonts), len(expected))
+  }
+  for i, f := range fonts {
+    if f.Face != i || f.Family != expected[i] || f.Filename != filename {
+      t.Errorf("fonts[%d] = %d %q %s ; expected %d %q %s",
+        i, f.Face, f.Family, f.Filename, i, expected[i], filename)
+    }
+    if s := f.Version.String(); s != "2.4.0" {
+      t.Errorf("fonts[%d].Version = %s ; expected 2.4.0", i, s)
+    }
+  }
+
+  local := NewLocalFontIndex(nil)
+  if err := local.Scandir(tmpdir); err != nil {
+    t.Fatal(err)
+  }
+  if n := len(local.FindFamily("Noto Sans CJK KR")); n != 1 {
+    t.Errorf("found %d faces of \"Noto Sans CJK KR\"; expected 1", n)
+  }
+}
+
+
+// makeTestWOFF wraps the tables of an sfnt font made by makeTestSfnt in an
+// uncompressed WOFF file
+//
+func makeTestWOFF(font []byte) []byte {
+  numTables := int(binary.BigEndian.Uint16(font[4:]))
+  var dir, data bytes.Buffer
+  offset := 44 + 20 * numTables
+  for i := 0; i < numTables; i++ {
+    r := font[12 + i * 16:]
+    start := binary.BigEndian.Uint32(r[8:])
+    length := binary.BigEndian.Uint32(r[12:])
+    dir.Write(r[:4])  // tag
+    binary.Write(&dir, binary.BigEndian, []uint32{
+      uint32(offset + data.Len()), length, length, binary.BigEndian.Uint32(r[4:]),
+    })
+    data.Write(font[start:start + length])
+    for data.Len() % 4 != 0 {
+      data.WriteByte(0)
+    }
+  }
+  var hdr bytes.Buffer
+  hdr.WriteString("wOFF")
+  hdr.Write(font[:4])  // flavor
+  binary.Write(&hdr, binary.BigEndian, uint32(offset + data.Len()))
+  binary.Write(&hdr, binary.BigEndian, []uint16{ uint16(numTables), 0 })
+  binary.Write(&hdr, binary.BigEndian, uint32(len(font)))  // totalSfntSize
+  hdr.Write(make([]byte, 44 - hdr.Len()))
+  return append(append(hdr.Bytes(), dir.Bytes()...), data.Bytes()...)
+}
+
+
+func TestParseWOFF(t *testing.T) {
+  tmpdir, err := ioutil.TempDir("", "fontctrl-test")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(tmpdir)
+
+  filename := filepath.Join(tmpdir, "Inter-Bold.woff")
+  data := makeTestWOFF(makeTestSfnt(map[string][]byte{
+    "head": makeTestHead(3.019),
+    "name": makeTestName(map[sfnt.NameID]string{
+      sfnt.NameFontFamily:    "Inter",
+      sfnt.NameFontSubfamily: "Bold",
+      sfnt.NameVersion:       "Version 3.019",
+    }, nil),
+  }))
+  if err := ioutil.WriteFile(filename, data, 0644); err != nil {
+    t.Fatal(err)
+  }
+
+  fonts, err := ParseFontFile(filename)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(fonts) != 1 {
+    t.Fatalf("got %d fonts; expected 1", len(fonts))
+  }
+  f := fonts[0]
+  if f.Family != "Inter" || f.Style != "Bold" || f.Version.String() != "3.19.0" {
+    t.Errorf("got %q %q %s ; expected \"Inter\" \"Bold\" 3.19.0",
+      f.Family, f.Style, f.Version.String())
+  }
+
+  local := NewLocalFontIndex(nil)
+  if err := local.Scandir(tmpdir); err != nil {
+    t.Fatal(err)
+  }
+  if n := len(local.FindFamily("Inter")); n != 1 {
+    t.Errorf("found %d fonts of \"Inter\"; expected 1", n)
+  }
+}
@@ -1,88 +1,206 @@
 package main
 
 import (
-  "path/filepath"
+  "context"
   "io/ioutil"
   "os"
+  "path/filepath"
+  "runtime"
   "sync"
 )
 
 type FSVisitor func(dir string, f os.FileInfo)error
 
+// FSScanner walks a directory tree, calling a visitor for every file.
+// Directories and files are processed by a bounded number of workers.
+// Symbolic links are followed; a directory reachable via several paths (e.g.
+// through a symlink cycle) is only scanned once.
+//
 type FSScanner struct {
+  // Concurrency is the maximum number of directories and files processed
+  // at once. Defaults to the number of CPUs.
+  Concurrency int
+
+  // Warn is called for problems which don't stop the scan, like a
+  // subdirectory that can't be read. Defaults to logging the error.
+  Warn func(err error)
+
   rootDir string
   visitor FSVisitor
-  visited map[string]struct{}
-  errch   chan error
-  resch   chan struct{}
+  readDir func(dir string) ([]os.FileInfo, error)
+
+  visitedmu sync.Mutex
+  visited   map[fileKey]struct{}  // directories queued for scanning
+}
+
+type fsScanJob struct {
+  dir  string
+  file os.FileInfo  // nil for directory jobs
+  root bool         // true for the job of rootDir
+}
+
+type fsScanResult struct {
+  jobs []fsScanJob  // found in a directory
+  err  error
 }
 
 
 func NewFSScanner(dir string, f FSVisitor) *FSScanner {
   return &FSScanner{
+    Concurrency: runtime.NumCPU(),
+    Warn: func(err error) { L.Printf("warning: %v", err) },
     rootDir: dir,
     visitor: f,
-    visited: make(map[string]struct{}),
-    errch: make(chan error),
-    resch: make(chan struct{}),
+    readDir: ioutil.ReadDir,
   }
 }
 
 
 func (s *FSScanner) Scan() error {
-  return s.scandir(s.rootDir)
+  return s.ScanContext(context.Background())
 }
 
 
-func (s *FSScanner) scandir(dir string) error {
-  files, err := ioutil.ReadDir(dir)
+// ScanContext scans the directory tree, stopping early when ctx is done.
+// Returns the first error returned by the visitor, an error if the root
+// directory can not be read, or ctx.Err() if the scan was cancelled.
+//
+func (s *FSScanner) ScanContext(ctx context.Context) error {
+  ctx, cancel := context.WithCancel(ctx)
+  defer cancel()
+
+  s.visited = make(map[fileKey]struct{})
+  if st, err := os.Stat(s.rootDir); err == nil {
+    s.markVisited(s.rootDir, st)
+  }
+
+  nworkers := s.Concurrency
+  if nworkers < 1 {
+    nworkers = 1
+  }
+  jobch := make(chan fsScanJob)
+  resch := make(chan fsScanResult)
+  for i := 0; i < nworkers; i++ {
+    go func() {
+      for job := range jobch {
+        resch <- s.run(ctx, job)
+      }
+    }()
+  }
+
+  // dispatch jobs to workers until there's no more work
+  queue := []fsScanJob{ fsScanJob{ dir: s.rootDir, root: true } }
+  active := 0
+  done := ctx.Done()
+  var firstErr error
+  for len(queue) > 0 || active > 0 {
+    var sendch chan fsScanJob
+    var next fsScanJob
+    if len(queue) > 0 {
+      sendch = jobch
+      next = queue[len(queue) - 1]
+    }
+    select {
+      case sendch <- next:
+        queue = queue[:len(queue) - 1]
+        active++
+      case res := <- resch:
+        active--
+        queue = append(queue, res.jobs...)
+        if res.err != nil && firstErr == nil {
+          firstErr = res.err
+          cancel()
+        }
+      case <- done:
+        queue = nil  // wait for active jobs to finish
+        done = nil
+    }
+  }
+  close(jobch)
+
+  if firstErr == nil {
+    firstErr = ctx.Err()
+  }
+  return firstErr
+}
+
+
+// run performs a job, returning any new jobs it found
+func (s *FSScanner) run(ctx context.Context, job fsScanJob) fsScanResult {
+  if ctx.Err() != nil {
+    return fsScanResult{}
+  }
+  if job.file != nil {
+    return fsScanResult{ err: s.visitor(job.dir, job.file) }
+  }
+  jobs, err := s.scandir(job.dir)
   if err != nil {
-    // TODO: ignore "no read access" errors for subdirectories
-    return err
+    if !job.root {
+      s.Warn(err)
+      err = nil
+    }
   }
+  return fsScanResult{ jobs: jobs, err: err }
+}
+
 
-  var wg sync.WaitGroup
-  var errch chan error
+func (s *FSScanner) scandir(dir string) ([]fsScanJob, error) {
+  files, err := s.readDir(dir)
+  if err != nil {
+    return nil, err
+  }
 
+  var jobs []fsScanJob
   for _, f := range files {
+    if f.Mode() & os.ModeSymlink != 0 {
+      // follow the link
+      st, err := os.Stat(filepath.Join(dir, f.Name()))
+      if err != nil {
+        s.Warn(err)  // e.g. dangling link
+        continue
+      }
+      f = &renamedFileInfo{ st, f.Name() }
+    }
     if f.IsDir() {
+      if f.Name()[0] == '.' {
+        // hidden directory, e.g. fontctrl's own state dir -- skip
+        continue
+      }
       dir2 := filepath.Join(dir, f.Name())
-      if _, ok := s.visited[dir2]; ok {
+      if !s.markVisited(dir2, f) {
         // we've visited this directory already -- skip
         continue
       }
-      s.visited[dir2] = struct{}{} // mark as visited
-
-      if errch == nil {
-        errch = make(chan error, 1)
-      }
-
-      wg.Add(1)
-      go func() {
-        if err := s.scandir(dir2); err != nil {
-          select {
-            case errch <- err:
-            default:
-          }
-        }
-        wg.Done()
-      }()
-
-    } else {
-      s.visitor(dir, f)
+      jobs = append(jobs, fsScanJob{ dir: dir2 })
+    } else if f.Mode().IsRegular() {
+      jobs = append(jobs, fsScanJob{ dir: dir, file: f })
     }
   }
+  return jobs, nil
+}
 
-  if errch != nil {
-    wg.Wait()
-    // grab first error that occured, if any
-    select {
-      case err := <- errch:
-        return err
-      default: // no error
-    }
+
+// markVisited records the directory at path as visited. Returns false if it
+// was visited already.
+//
+func (s *FSScanner) markVisited(path string, f os.FileInfo) bool {
+  key := fileKeyOf(path, f)
+  s.visitedmu.Lock()
+  defer s.visitedmu.Unlock()
+  if _, ok := s.visited[key]; ok {
+    return false
   }
+  s.visited[key] = struct{}{}
+  return true
+}
+
 
-  return nil
+// renamedFileInfo is the FileInfo of a symlink's target, with the name of
+// the symlink
+//
+type renamedFileInfo struct {
+  os.FileInfo
+  name string
 }
 
+func (f *renamedFileInfo) Name() string { return f.name }
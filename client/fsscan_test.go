@@ -0,0 +1,192 @@
+package main
+
+import (
+  "context"
+  "errors"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "runtime"
+  "sort"
+  "strings"
+  "sync"
+  "sync/atomic"
+  "testing"
+  "time"
+)
+
+func makeTestTree(t *testing.T) string {
+  tmpdir, err := ioutil.TempDir("", "fontctrl-test")
+  if err != nil {
+    t.Fatal(err)
+  }
+  for _, name := range []string{
+    "a.otf", "b.otf",
+    "x/c.otf", "x/y/d.otf", "x/y/z/e.otf",
+    "w/f.otf", ".fontctrl/txn-1/new/g.otf",
+  } {
+    writeTestFile(t, filepath.Join(tmpdir, filepath.FromSlash(name)), name)
+  }
+  return tmpdir
+}
+
+// collect returns a visitor which records the names of visited files
+func collect(names *[]string) FSVisitor {
+  var mu sync.Mutex
+  return func(dir string, f os.FileInfo) error {
+    mu.Lock()
+    defer mu.Unlock()
+    *names = append(*names, f.Name())
+    return nil
+  }
+}
+
+func expectNames(t *testing.T, names []string, expected string) {
+  sort.Strings(names)
+  if s := strings.Join(names, " "); s != expected {
+    t.Errorf("visited %q ; expected %q", s, expected)
+  }
+}
+
+
+func TestFSScanner(t *testing.T) {
+  dir := makeTestTree(t)
+  defer os.RemoveAll(dir)
+
+  for _, concurrency := range []int{ 1, 2, 16 } {
+    var names []string
+    s := NewFSScanner(dir, collect(&names))
+    s.Concurrency = concurrency
+    if err := s.Scan(); err != nil {
+      t.Fatal(err)
+    }
+    expectNames(t, names, "a.otf b.otf c.otf d.otf e.otf f.otf")
+  }
+
+  s := NewFSScanner(filepath.Join(dir, "nonexistent"), collect(new([]string)))
+  if err := s.Scan(); err == nil {
+    t.Errorf("expected error for missing root directory")
+  }
+}
+
+
+func TestFSScannerConcurrencyLimit(t *testing.T) {
+  dir := makeTestTree(t)
+  defer os.RemoveAll(dir)
+
+  var active, maxActive int32
+  s := NewFSScanner(dir, func(dir string, f os.FileInfo) error {
+    n := atomic.AddInt32(&active, 1)
+    for {
+      m := atomic.LoadInt32(&maxActive)
+      if n <= m || atomic.CompareAndSwapInt32(&maxActive, m, n) {
+        break
+      }
+    }
+    time.Sleep(time.Millisecond)
+    atomic.AddInt32(&active, -1)
+    return nil
+  })
+  s.Concurrency = 2
+  if err := s.Scan(); err != nil {
+    t.Fatal(err)
+  }
+  if maxActive > 2 {
+    t.Errorf("%d files visited at once; expected at most 2", maxActive)
+  }
+}
+
+
+func TestFSScannerSymlinks(t *testing.T) {
+  if runtime.GOOS == "windows" {
+    t.Skip("symlinks require privileges on windows")
+  }
+  dir := makeTestTree(t)
+  defer os.RemoveAll(dir)
+
+  for oldname, newname := range map[string]string{
+    dir:                                    "x/y/loop",  // cycle
+    filepath.Join(dir, "x", "y"):           "w/y",  // same dir via two paths
+    filepath.Join(dir, "a.otf"):            "w/a-link.otf",
+    filepath.Join(dir, "nonexistent.otf"):  "w/dangling.otf",
+  } {
+    if err := os.Symlink(oldname, filepath.Join(dir, newname)); err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  var names []string
+  var warnings int32
+  s := NewFSScanner(dir, collect(&names))
+  s.Warn = func(err error) { atomic.AddInt32(&warnings, 1) }
+  if err := s.Scan(); err != nil {
+    t.Fatal(err)
+  }
+  expectNames(t, names, "a-link.otf a.otf b.otf c.otf d.otf e.otf f.otf")
+  if warnings != 1 {
+    t.Errorf("got %d warnings; expected 1 (for dangling link)", warnings)
+  }
+}
+
+
+func TestFSScannerUnreadableDir(t *testing.T) {
+  dir := makeTestTree(t)
+  defer os.RemoveAll(dir)
+
+  var names []string
+  var warned []error
+  s := NewFSScanner(dir, collect(&names))
+  s.Concurrency = 1  // so that Warn needn't be synchronized
+  s.Warn = func(err error) { warned = append(warned, err) }
+  s.readDir = func(d string) ([]os.FileInfo, error) {
+    if filepath.Base(d) == "y" {
+      return nil, &os.PathError{ Op: "open", Path: d, Err: os.ErrPermission }
+    }
+    return ioutil.ReadDir(d)
+  }
+  if err := s.Scan(); err != nil {
+    t.Fatal(err)
+  }
+  expectNames(t, names, "a.otf b.otf c.otf f.otf")
+  if len(warned) != 1 || !os.IsPermission(warned[0]) {
+    t.Errorf("warnings = %v ; expected one permission error", warned)
+  }
+}
+
+
+func TestFSScannerVisitorError(t *testing.T) {
+  dir := makeTestTree(t)
+  defer os.RemoveAll(dir)
+
+  errFail := errors.New("fail")
+  s := NewFSScanner(dir, func(dir string, f os.FileInfo) error {
+    if f.Name() == "d.otf" {
+      return errFail
+    }
+    return nil
+  })
+  if err := s.Scan(); err != errFail {
+    t.Errorf("Scan() = %v ; expected %v", err, errFail)
+  }
+}
+
+
+func TestFSScannerCancel(t *testing.T) {
+  dir := makeTestTree(t)
+  defer os.RemoveAll(dir)
+
+  ctx, cancel := context.WithCancel(context.Background())
+  var visited int32
+  s := NewFSScanner(dir, func(dir string, f os.FileInfo) error {
+    atomic.AddInt32(&visited, 1)
+    cancel()
+    return nil
+  })
+  s.Concurrency = 1
+  if err := s.ScanContext(ctx); err != context.Canceled {
+    t.Errorf("ScanContext() = %v ; expected %v", err, context.Canceled)
+  }
+  if visited != 1 {
+    t.Errorf("visited %d files after cancel; expected 1", visited)
+  }
+}
@@ -0,0 +1,21 @@
+// +build !windows
+
+package main
+
+import (
+  "os"
+  "syscall"
+)
+
+// fileKey identifies a file independently of the path used to reach it
+type fileKey struct {
+  dev, ino uint64
+  path     string  // used when the device and inode are not known
+}
+
+func fileKeyOf(path string, f os.FileInfo) fileKey {
+  if st, ok := f.Sys().(*syscall.Stat_t); ok {
+    return fileKey{ dev: uint64(st.Dev), ino: uint64(st.Ino) }
+  }
+  return fileKey{ path: path }
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+  "os"
+  "path/filepath"
+)
+
+// fileKey identifies a file independently of the path used to reach it
+type fileKey struct {
+  path string
+}
+
+// fileKeyOf returns the path of f with all symlinks resolved, since
+// os.FileInfo does not carry a file index on Windows
+//
+func fileKeyOf(path string, f os.FileInfo) fileKey {
+  if p, err := filepath.EvalSymlinks(path); err == nil {
+    path = p
+  }
+  if p, err := filepath.Abs(path); err == nil {
+    path = p
+  }
+  return fileKey{ path: path }
+}
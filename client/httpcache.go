@@ -0,0 +1,151 @@
+package main
+
+import (
+  "crypto/sha256"
+  "encoding/hex"
+  "encoding/json"
+  "io/ioutil"
+  "net/http"
+  "os"
+  "path/filepath"
+  "strconv"
+  "strings"
+  "time"
+)
+
+// HTTPCache is an on-disk cache of repo metadata fetched over HTTP, like
+// index.json. Entries are revalidated with conditional requests using the
+// ETag and Last-Modified of the response, unless still fresh according to
+// the Cache-Control max-age of the response.
+//
+type HTTPCache struct {
+  Dir     string  // where entries are stored; caching is disabled if empty
+  Offline bool    // serve from the cache only, without any requests
+}
+
+type HTTPCacheEntry struct {
+  URL          string    `json:"url"`
+  ETag         string    `json:"etag,omitempty"`
+  LastModified string    `json:"last_modified,omitempty"`
+  Expires      time.Time `json:"expires"`  // fresh until; zero = revalidate
+  Body         []byte    `json:"body"`
+}
+
+// httpCache is used by repos served over HTTP. main enables it by setting
+// Dir.
+var httpCache = &HTTPCache{}
+
+
+// httpCacheDir returns the directory of the HTTP cache in the user's cache
+// directory, or an empty string if there is no such directory
+//
+func httpCacheDir() string {
+  dir, err := os.UserCacheDir()
+  if err != nil {
+    return ""
+  }
+  return filepath.Join(dir, "fontctrl", "http")
+}
+
+
+func (c *HTTPCache) filename(url string) string {
+  sum := sha256.Sum256([]byte(url))
+  return filepath.Join(c.Dir, hex.EncodeToString(sum[:16]) + ".json")
+}
+
+
+// Lookup returns the cached entry for url, or nil if there is none
+//
+func (c *HTTPCache) Lookup(url string) *HTTPCacheEntry {
+  if len(c.Dir) == 0 {
+    return nil
+  }
+  filename := c.filename(url)
+  data, err := ioutil.ReadFile(filename)
+  if err != nil {
+    if !os.IsNotExist(err) {
+      L.Printf("ignoring cache entry %s: %v", filename, err)
+    }
+    return nil
+  }
+  e := &HTTPCacheEntry{}
+  if err := json.Unmarshal(data, e); err != nil || e.URL != url {
+    return nil  // invalid, or a hash collision
+  }
+  return e
+}
+
+
+// Store records a response to a request for url with the body body.
+// Responses with "Cache-Control: no-store" are not stored.
+//
+func (c *HTTPCache) Store(url string, res *http.Response, body []byte) error {
+  if len(c.Dir) == 0 {
+    return nil
+  }
+  maxAge, noStore := parseCacheControl(res.Header.Get("Cache-Control"))
+  if noStore {
+    return nil
+  }
+  e := &HTTPCacheEntry{
+    URL:          url,
+    ETag:         res.Header.Get("ETag"),
+    LastModified: res.Header.Get("Last-Modified"),
+    Body:         body,
+  }
+  if maxAge > 0 {
+    e.Expires = time.Now().Add(maxAge)
+  }
+  data, err := json.Marshal(e)
+  if err != nil {
+    return err
+  }
+  // the cache may contain metadata of private repos
+  return writeFileAtomic(c.filename(url), data, 0600)
+}
+
+
+// Fresh returns true if e can be used without revalidating it
+//
+func (e *HTTPCacheEntry) Fresh() bool {
+  return time.Now().Before(e.Expires)
+}
+
+
+// addConditions makes req conditional on e having changed
+//
+func (e *HTTPCacheEntry) addConditions(req *http.Request) {
+  if len(e.ETag) > 0 {
+    req.Header.Set("If-None-Match", e.ETag)
+  }
+  if len(e.LastModified) > 0 {
+    req.Header.Set("If-Modified-Since", e.LastModified)
+  }
+}
+
+
+// parseCacheControl returns the max-age of a Cache-Control header value,
+// which is 0 when responses must be revalidated ("no-cache"), and whether
+// responses may be stored at all
+//
+func parseCacheControl(s string) (maxAge time.Duration, noStore bool) {
+  noCache := false
+  for _, directive := range strings.Split(s, ",") {
+    directive = strings.ToLower(strings.TrimSpace(directive))
+    switch {
+    case directive == "no-store":
+      noStore = true
+    case directive == "no-cache":
+      noCache = true
+    case strings.HasPrefix(directive, "max-age="):
+      n, err := strconv.ParseInt(directive[len("max-age="):], 10, 64)
+      if err == nil && n > 0 {
+        maxAge = time.Duration(n) * time.Second
+      }
+    }
+  }
+  if noCache {
+    maxAge = 0
+  }
+  return
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+  "io"
+  "io/ioutil"
+  "net/http"
+  "net/http/httptest"
+  "os"
+  "testing"
+  "time"
+)
+
+func TestHTTPCache(t *testing.T) {
+  tmpdir, err := ioutil.TempDir("", "fontctrl-test")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(tmpdir)
+
+  saved := httpCache
+  httpCache = &HTTPCache{ Dir: tmpdir }
+  defer func() { httpCache = saved }()
+
+  const etag = `"v1"`
+  const lastModified = "Mon, 02 Jan 2006 15:04:05 GMT"
+  cacheControl := ""
+  var requests, notModified int
+  srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    requests++
+    if len(cacheControl) > 0 {
+      w.Header().Set("Cache-Control", cacheControl)
+    }
+    if r.URL.Path == "/index.json" {
+      if r.Header.Get("If-None-Match") == etag &&
+         r.Header.Get("If-Modified-Since") == lastModified {
+        notModified++
+        w.WriteHeader(http.StatusNotModified)
+        return
+      }
+      w.Header().Set("ETag", etag)
+      w.Header().Set("Last-Modified", lastModified)
+    }
+    io.WriteString(w, testRepoIndex)
+  }))
+
+  update := func(name string, expectRequests, expectNotModified int) {
+    requests, notModified = 0, 0
+    repo := &Repo{ Url: srv.URL }
+    if err := repo.Update(); err != nil {
+      t.Errorf("%s: %v", name, err)
+    } else if repo.Index.Fonts["inter"] == nil {
+      t.Errorf("%s: unexpected index %+v", name, repo.Index)
+    }
+    if requests != expectRequests || notModified != expectNotModified {
+      t.Errorf("%s: %d requests, %d not modified ; expected %d, %d",
+        name, requests, notModified, expectRequests, expectNotModified)
+    }
+  }
+
+  update("first", 1, 0)
+  update("revalidated", 1, 1)
+
+  cacheControl = "public, max-age=3600"
+  update("max-age", 1, 1)
+  update("fresh", 0, 0)
+  e := httpCache.Lookup(srv.URL + "/index.json")
+  if e == nil || e.ETag != etag || e.LastModified != lastModified {
+    t.Fatalf("validators lost on 304 response: %+v", e)
+  }
+
+  // expire the entry
+  cacheControl = "no-cache"
+  httpCache.Store(e.URL, &http.Response{ Header: http.Header{
+    "Etag": { e.ETag }, "Last-Modified": { e.LastModified },
+  }}, e.Body)
+  update("no-cache", 1, 1)
+  update("no-cache again", 1, 1)
+
+  // offline, and when the server is unavailable
+  httpCache.Offline = true
+  update("offline", 0, 0)
+  if err := (&Repo{ Url: srv.URL + "/other" }).Update(); err == nil {
+    t.Errorf("expected error for uncached url when offline")
+  }
+  httpCache.Offline = false
+  srv.Close()
+  update("unavailable", 0, 0)
+
+  // no-store responses are not cached
+  srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Cache-Control", "no-store")
+    io.WriteString(w, testRepoIndex)
+  }))
+  defer srv.Close()
+  if err := (&Repo{ Url: srv.URL }).Update(); err != nil {
+    t.Fatal(err)
+  }
+  if e := httpCache.Lookup(srv.URL + "/index.json"); e != nil {
+    t.Errorf("no-store response was cached")
+  }
+}
+
+
+func TestParseCacheControl(t *testing.T) {
+  tests := []struct {
+    header  string
+    maxAge  time.Duration
+    noStore bool
+  }{
+    { "", 0, false },
+    { "max-age=60", time.Minute, false },
+    { "public, MAX-AGE=3600", time.Hour, false },
+    { "max-age=60, no-cache", 0, false },
+    { "no-store", 0, true },
+    { "max-age=x", 0, false },
+  }
+  for _, test := range tests {
+    maxAge, noStore := parseCacheControl(test.header)
+    if maxAge != test.maxAge || noStore != test.noStore {
+      t.Errorf("%q: got %v, %v ; expected %v, %v",
+        test.header, maxAge, noStore, test.maxAge, test.noStore)
+    }
+  }
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+  "encoding/json"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "strings"
+  "sync"
+  "time"
+)
+
+// indexCacheFormat is the format version of the index cache file.
+// Bump when FontFile or the way fonts are parsed changes, which causes
+// existing caches to be discarded.
+const indexCacheFormat = 6
+
+// IndexCache is a persistent cache of parsed font files, so that files which
+// have not changed since the last scan needn't be parsed again. Files are
+// considered unchanged when their size and modification time are the same.
+//
+type IndexCache struct {
+  Format int                         `json:"format"`
+  Files  map[string]*IndexCacheEntry `json:"files"`  // keyed by filename
+
+  File  string              `json:"-"`
+  mu    sync.Mutex          // protects Files, seen and dirty
+  seen  map[string]struct{} // files visited since the last call to Evict
+  dirty bool                // true when Files has changed since loaded
+}
+
+type IndexCacheEntry struct {
+  Size    int64       `json:"size"`
+  ModTime time.Time   `json:"mtime"`
+  Fonts   []*FontFile `json:"fonts,omitempty"`  // more than one for collections
+  Error   string      `json:"error,omitempty"`  // set if the file failed to parse
+}
+
+
+// indexCacheFile returns the path of the index cache file in the user's
+// cache directory, or an empty string if there is no such directory
+//
+func indexCacheFile() string {
+  dir, err := os.UserCacheDir()
+  if err != nil {
+    return ""
+  }
+  return filepath.Join(dir, "fontctrl", "localindex.json")
+}
+
+
+// LoadIndexCache reads the index cache from filename. A missing, unreadable
+// or outdated cache results in an empty cache rather than an error, since
+// the cache can always be rebuilt.
+//
+func LoadIndexCache(filename string) *IndexCache {
+  c := &IndexCache{ File: filename }
+  data, err := ioutil.ReadFile(filename)
+  if err == nil {
+    if err = json.Unmarshal(data, c); err != nil {
+      L.Printf("ignoring invalid index cache %s: %v", filename, err)
+    }
+  } else if !os.IsNotExist(err) {
+    L.Printf("ignoring index cache %s: %v", filename, err)
+  }
+  if err != nil || c.Format != indexCacheFormat || c.Files == nil {
+    c.Reset()
+  }
+  return c
+}
+
+
+// Reset empties the cache, causing all files to be parsed again
+//
+func (c *IndexCache) Reset() {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  c.Format = indexCacheFormat
+  c.Files = make(map[string]*IndexCacheEntry)
+  c.dirty = true
+}
+
+
+// Save writes c to c.File if it has changed
+//
+func (c *IndexCache) Save() error {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  if !c.dirty {
+    return nil
+  }
+  data, err := json.Marshal(c)
+  if err != nil {
+    return err
+  }
+  if err := writeFileAtomic(c.File, data, 0644); err != nil {
+    return err
+  }
+  c.dirty = false
+  return nil
+}
+
+
+// Lookup returns the cached entry for filename if file has not changed since
+// the entry was stored.
+//
+func (c *IndexCache) Lookup(filename string, file os.FileInfo) *IndexCacheEntry {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  c.markSeen(filename)
+  e := c.Files[filename]
+  if e == nil || e.Size != file.Size() || !e.ModTime.Equal(file.ModTime()) {
+    return nil
+  }
+  return e
+}
+
+
+// Store records the result of parsing filename
+//
+func (c *IndexCache) Store(
+  filename string,
+  file os.FileInfo,
+  fonts []*FontFile,
+  err error,
+) {
+  e := &IndexCacheEntry{ Size: file.Size(), ModTime: file.ModTime() }
+  if err != nil {
+    e.Error = err.Error()
+  } else {
+    e.Fonts = fonts
+  }
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  c.markSeen(filename)
+  c.Files[filename] = e
+  c.dirty = true
+}
+
+
+// Evict removes entries for files in dir which have not been looked up or
+// stored since the last call to Evict, i.e. files which have been deleted.
+//
+func (c *IndexCache) Evict(dir string) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  prefix := withTrailingSeparator(filepath.Clean(dir))
+  for filename := range c.Files {
+    if !strings.HasPrefix(filename, prefix) {
+      continue  // not in dir
+    }
+    if _, ok := c.seen[filename]; !ok {
+      delete(c.Files, filename)
+      c.dirty = true
+    }
+  }
+  c.seen = nil
+}
+
+
+func (c *IndexCache) markSeen(filename string) {
+  if c.seen == nil {
+    c.seen = make(map[string]struct{})
+  }
+  c.seen[filename] = struct{}{}
+}
+
+
+func withTrailingSeparator(dir string) string {
+  if len(dir) == 0 || os.IsPathSeparator(dir[len(dir)-1]) {
+    return dir
+  }
+  return dir + string(filepath.Separator)
+}
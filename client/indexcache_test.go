@@ -0,0 +1,91 @@
+package main
+
+import (
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "testing"
+  "time"
+)
+
+func TestIndexCache(t *testing.T) {
+  tmpdir, err := ioutil.TempDir("", "fontctrl-test")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(tmpdir)
+
+  fontdir := filepath.Join(tmpdir, "fonts")
+  a := filepath.Join(fontdir, "A-Regular.otf")
+  b := filepath.Join(fontdir, "B-Regular.otf")
+  writeTestFile(t, a, "not really a font")
+  writeTestFile(t, b, "not really a font either")
+
+  // prime the cache with a parsed font for a, as if it had been scanned
+  cache := LoadIndexCache(filepath.Join(tmpdir, "cache", "localindex.json"))
+  st, err := os.Stat(a)
+  if err != nil {
+    t.Fatal(err)
+  }
+  cache.Store(a, st, []*FontFile{
+    &FontFile{ Filename: a, Family: "A", Style: "Regular" },
+  }, nil)
+  cache.Evict(fontdir)
+
+  local := NewLocalFontIndex(cache)
+  if err := local.Scandir(fontdir); err != nil {
+    t.Fatal(err)
+  }
+  if fonts := local.FindFamily("A"); len(fonts) != 1 {
+    t.Errorf("expected cached font for %s", a)
+  }
+  if e := cache.Files[b]; e == nil || len(e.Fonts) != 0 || len(e.Error) == 0 {
+    t.Errorf("expected failed parse of %s to be cached; got %+v", b, e)
+  }
+  if err := cache.Save(); err != nil {
+    t.Fatal(err)
+  }
+
+  // a changed file is parsed again and a deleted file is evicted
+  mtime := st.ModTime().Add(time.Second)
+  if err := os.Chtimes(a, mtime, mtime); err != nil {
+    t.Fatal(err)
+  }
+  if err := os.Remove(b); err != nil {
+    t.Fatal(err)
+  }
+  cache = LoadIndexCache(cache.File)
+  if len(cache.Files) != 2 {
+    t.Fatalf("loaded cache has %d entries; expected 2", len(cache.Files))
+  }
+  local = NewLocalFontIndex(cache)
+  if err := local.Scandir(fontdir); err != nil {
+    t.Fatal(err)
+  }
+  if fonts := local.FindFamily("A"); len(fonts) != 0 {
+    t.Errorf("expected %s to be parsed again", a)
+  }
+  if _, ok := cache.Files[b]; ok {
+    t.Errorf("expected %s to be evicted", b)
+  }
+  if len(cache.Files) != 1 {
+    t.Errorf("cache has %d entries; expected 1", len(cache.Files))
+  }
+}
+
+
+func TestIndexCacheOutdatedFormat(t *testing.T) {
+  tmpdir, err := ioutil.TempDir("", "fontctrl-test")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(tmpdir)
+
+  filename := filepath.Join(tmpdir, "localindex.json")
+  writeTestFile(t, filename,
+    `{"format":0,"files":{"/fonts/A.otf":{"size":1,"mtime":"2018-01-01T00:00:00Z"}}}`)
+  cache := LoadIndexCache(filename)
+  if len(cache.Files) != 0 || cache.Format != indexCacheFormat {
+    t.Errorf("expected outdated cache to be discarded")
+  }
+}
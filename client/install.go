@@ -0,0 +1,298 @@
+package main
+
+import (
+  "archive/zip"
+  "crypto/sha1"
+  "encoding/hex"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "os"
+  Path "path"
+  "path/filepath"
+  "strings"
+  "time"
+)
+
+
+// downloadFontVersion downloads and verifies the archive for version i of
+// findex. Returns the name of a temporary file, which the caller should
+// remove.
+//
+func downloadFontVersion(findex *FontIndex, i int, fvi *FontVersionInfo) (string, error) {
+  url, err := findex.GetArchiveUrlAt(i, fvi)
+  if err != nil {
+    return "", err
+  }
+  L.Printf("downloading %s", redactURL(url))
+  return downloadArchive(findex.Repo, url, fvi.Checksum)
+}
+
+
+// installFontVersion installs the font files of archive, the archive for
+// version i of findex as returned by downloadFontVersion, into dir,
+// replacing any files in locals (the currently-installed files of the same
+// family) that are not overwritten by the new version. Files are swapped in
+// as a transaction.
+//
+// Only files with a receipt (i.e. files installed by fontctrl) are ever
+// replaced or removed. Installing is refused if it would overwrite a file
+// that fontctrl did not install.
+//
+func installFontVersion(
+  dir string,
+  findex *FontIndex,
+  i int,
+  fvi *FontVersionInfo,
+  locals []*FontFile,
+  archive string,
+) error {
+  receipts, err := LoadReceipts(dir)
+  if err != nil {
+    return err
+  }
+
+  txn, err := BeginTxn(dir)
+  if err != nil {
+    return err
+  }
+
+  names, err := extractFonts(archive, txn.StagePath(""))
+  if err == nil && len(names) == 0 {
+    err = fmt.Errorf("no font files found in archive of %s %s",
+      findex.Id, findex.Versions[i])
+  }
+  if err != nil {
+    txn.Abort()
+    return err
+  }
+
+  installed := make(map[string]struct{}, len(names))
+  for _, name := range names {
+    if fileExists(filepath.Join(dir, name)) && !receipts.IsManaged(name) {
+      txn.Abort()
+      return fmt.Errorf(
+        "refusing to overwrite %s which was not installed by fontctrl",
+        filepath.Join(dir, name))
+    }
+    hash, err := hashFile(txn.StagePath(name))
+    if err != nil {
+      txn.Abort()
+      return err
+    }
+    txn.Install(name, &Receipt{
+      Font:      findex.Id,
+      Version:   findex.Versions[i],
+      Repo:      findex.Repo.Url,
+      Checksum:  strings.ToLower(strings.TrimSpace(fvi.Checksum)),
+      Hash:      hash,
+      Installed: time.Now().UTC(),
+    })
+    installed[name] = struct{}{}
+  }
+
+  // remove files of older versions which are not overwritten
+  var removed []*FontFile
+  seen := make(map[string]struct{}, len(locals))
+  for _, lf := range locals {
+    name, err := filepath.Rel(dir, lf.Filename)
+    if err != nil || strings.HasPrefix(name, "..") {
+      continue  // not in dir
+    }
+    if _, ok := installed[name]; ok {
+      continue
+    }
+    if _, ok := seen[name]; ok {
+      continue  // another face of a font collection
+    }
+    seen[name] = struct{}{}
+    if !receipts.IsManaged(name) {
+      L.Printf("leaving %s (not installed by fontctrl)", lf.Filename)
+      continue
+    }
+    txn.Remove(name)
+    removed = append(removed, lf)
+  }
+
+  if err := txn.Commit(); err != nil {
+    return err
+  }
+
+  for _, name := range names {
+    L.Printf("installed %s", filepath.Join(dir, name))
+  }
+  for _, lf := range removed {
+    L.Printf("removed %s (%s)", lf.Filename, lf.Version.String())
+  }
+
+  return nil
+}
+
+
+// uninstallFont removes all files that fontctrl installed for the font fid
+// from dir. Files that have been modified since they were installed are
+// left alone and returned as kept.
+//
+func uninstallFont(
+  dir string,
+  receipts *ReceiptDB,
+  fid string,
+) (removed, kept []string, err error) {
+  return removeManagedFiles(dir, receipts, receipts.FontFiles(fid))
+}
+
+
+// removeManagedFiles removes filenames, which must have receipts, from dir
+// in a single transaction. Files that have been modified since they were
+// installed are left alone and returned as kept.
+//
+func removeManagedFiles(
+  dir string,
+  receipts *ReceiptDB,
+  filenames []string,
+) (removed, kept []string, err error) {
+  txn, err := BeginTxn(dir)
+  if err != nil {
+    return nil, nil, err
+  }
+
+  for _, filename := range filenames {
+    if receipts.Get(filename) == nil {
+      continue  // not ours
+    }
+    if fileExists(filename) && !receipts.IsManaged(filename) {
+      kept = append(kept, filename)
+      continue
+    }
+    name, _ := filepath.Rel(dir, filename)
+    txn.Remove(name)  // also drops the receipt of already-deleted files
+    if fileExists(filename) {
+      removed = append(removed, filename)
+    }
+  }
+
+  if err := txn.Commit(); err != nil {
+    return nil, nil, err
+  }
+  return removed, kept, nil
+}
+
+
+// downloadArchive fetches url from repo into a temporary file and verifies
+// that the SHA-1 checksum of its contents matches checksum.
+// Returns the name of the temporary file, which the caller should remove.
+//
+func downloadArchive(repo *Repo, url, checksum string) (string, error) {
+  checksum = strings.TrimSpace(checksum)
+  if len(checksum) == 0 {
+    return "", fmt.Errorf("missing checksum for %s", redactURL(url))
+  }
+
+  src, err := repo.Source()
+  if err != nil {
+    return "", err
+  }
+  rc, err := src.OpenArchive(url)
+  if err != nil {
+    return "", err
+  }
+  defer rc.Close()
+
+  fp, err := ioutil.TempFile("", "fontctrl-")
+  if err != nil {
+    return "", err
+  }
+
+  h := sha1.New()
+  _, err = io.Copy(io.MultiWriter(fp, h), rc)
+  if err2 := fp.Close(); err == nil {
+    err = err2
+  }
+  if err != nil {
+    os.Remove(fp.Name())
+    return "", err
+  }
+
+  sum := hex.EncodeToString(h.Sum(nil))
+  if !strings.EqualFold(sum, checksum) {
+    os.Remove(fp.Name())
+    return "", fmt.Errorf(
+      "checksum mismatch for %s; expected %s but got %s",
+      redactURL(url), checksum, sum)
+  }
+
+  return fp.Name(), nil
+}
+
+
+// extractFonts writes all font files found in the zip archive at filename
+// into dir. Directory structure of the archive is not preserved, so it is
+// an error for the archive to contain several font files of the same name
+// (ignoring case, like the file systems of macOS and Windows do.)
+// Returns the names of the files written, relative to dir.
+//
+func extractFonts(filename, dir string) ([]string, error) {
+  zr, err := zip.OpenReader(filename)
+  if err != nil {
+    return nil, err
+  }
+  defer zr.Close()
+
+  if err := os.MkdirAll(dir, 0755); err != nil {
+    return nil, err
+  }
+
+  var names []string
+  seen := make(map[string]string)  // lower-case name => archive entry
+
+  for _, zf := range zr.File {
+    if zf.FileInfo().IsDir() || isArchiveJunk(zf.Name) {
+      continue
+    }
+    name := filepath.Base(filepath.FromSlash(zf.Name))
+    if _, ok := extToFontType[strings.ToLower(filepath.Ext(name))]; !ok {
+      continue
+    }
+    if other, ok := seen[strings.ToLower(name)]; ok {
+      return names, fmt.Errorf(
+        "archive contains several font files named %s (%s and %s)",
+        name, other, zf.Name)
+    }
+    seen[strings.ToLower(name)] = zf.Name
+
+    if err := extractZipFile(zf, filepath.Join(dir, name)); err != nil {
+      return names, err
+    }
+    names = append(names, name)
+  }
+
+  return names, nil
+}
+
+
+func extractZipFile(zf *zip.File, dstname string) error {
+  r, err := zf.Open()
+  if err != nil {
+    return err
+  }
+  defer r.Close()
+
+  fp, err := os.OpenFile(dstname, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+  if err != nil {
+    return err
+  }
+  _, err = io.Copy(fp, r)
+  if err2 := fp.Close(); err == nil {
+    err = err2
+  }
+  return err
+}
+
+
+// isArchiveJunk returns true for entries like "__MACOSX/..." and "._name"
+// which are metadata added by some archivers rather than real files.
+//
+func isArchiveJunk(name string) bool {
+  return strings.HasPrefix(name, "__MACOSX/") ||
+         strings.HasPrefix(Path.Base(name), "._")
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+  "archive/zip"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "reflect"
+  "testing"
+)
+
+// writeTestZip writes a zip archive with the files in entries, in order
+//
+func writeTestZip(t *testing.T, filename string, entries [][2]string) {
+  fp, err := os.Create(filename)
+  if err != nil {
+    t.Fatal(err)
+  }
+  zw := zip.NewWriter(fp)
+  for _, e := range entries {
+    w, err := zw.Create(e[0])
+    if err != nil {
+      t.Fatal(err)
+    }
+    w.Write([]byte(e[1]))
+  }
+  if err := zw.Close(); err != nil {
+    t.Fatal(err)
+  }
+  fp.Close()
+}
+
+
+func TestExtractFonts(t *testing.T) {
+  tmpdir, err := ioutil.TempDir("", "fontctrl-test")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(tmpdir)
+
+  archive := filepath.Join(tmpdir, "inter.zip")
+  writeTestZip(t, archive, [][2]string{
+    { "Inter/", "" },
+    { "Inter/README.txt", "readme" },
+    { "Inter/otf/Inter-Regular.otf", "regular" },
+    { "Inter/otf/Inter-Bold.OTF", "bold" },
+    { "__MACOSX/Inter/otf/._Inter-Regular.otf", "junk" },
+    { "Inter/otf/._Inter-Bold.otf", "junk" },
+  })
+  dir := filepath.Join(tmpdir, "out")
+  names, err := extractFonts(archive, dir)
+  if err != nil {
+    t.Fatal(err)
+  }
+  expected := []string{ "Inter-Regular.otf", "Inter-Bold.OTF" }
+  if !reflect.DeepEqual(names, expected) {
+    t.Errorf("extracted %q ; expected %q", names, expected)
+  }
+  if data, _ := ioutil.ReadFile(filepath.Join(dir, "Inter-Regular.otf")); string(data) != "regular" {
+    t.Errorf("Inter-Regular.otf = %q ; expected \"regular\"", data)
+  }
+
+  // files of the same name in different directories would overwrite
+  // each other
+  for _, other := range []string{ "Inter/ttf/Inter-Regular.otf", "inter-regular.otf" } {
+    writeTestZip(t, archive, [][2]string{
+      { "Inter/otf/Inter-Regular.otf", "regular" },
+      { other, "other" },
+    })
+    if _, err := extractFonts(archive, filepath.Join(tmpdir, "dup")); err == nil {
+      t.Errorf("expected error for duplicate %s", other)
+    }
+  }
+}
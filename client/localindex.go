@@ -1,24 +1,44 @@
 package main
 
 import (
+  "fmt"
+  "io"
   "os"
   "path/filepath"
+  "sort"
   "sync"
   "strings"
+  "text/tabwriter"
 )
 
 type LocalFontIndex struct {
   fontsmu       sync.RWMutex  // protects access to fonts and fontsByFamily
   fonts         []*FontFile   // all font files
-  fontsByFamily map[string][]*FontFile  // keyed by family name
+  fontsByFamily map[string][]*FontFile  // keyed by any localized family name
+  cache         *IndexCache   // optional; avoids parsing unchanged files
+}
+
+
+// NewLocalFontIndex creates an index which uses cache, which may be nil
+//
+func NewLocalFontIndex(cache *IndexCache) *LocalFontIndex {
+  return &LocalFontIndex{ cache: cache }
 }
 
 
 func (l *LocalFontIndex) Scandir(dir string) error {
-  return NewFSScanner(dir, l.visitFile).Scan()
+  err := NewFSScanner(dir, l.visitFile).Scan()
+  if err == nil && l.cache != nil {
+    // only evict after a complete scan, as files we didn't get to may exist
+    l.cache.Evict(dir)
+  }
+  return err
 }
 
 
+// FindFamily returns the fonts which have family as any of their localized
+// family names
+//
 func (l *LocalFontIndex) FindFamily(family string) []*FontFile {
   l.fontsmu.RLock()
   defer l.fontsmu.RUnlock()
@@ -31,6 +51,58 @@ func (l *LocalFontIndex) FindFamily(family string) []*FontFile {
 }
 
 
+// FindFamilies returns the fonts which belong to any of families, in order
+// of families
+//
+func (l *LocalFontIndex) FindFamilies(families []string) []*FontFile {
+  var fonts []*FontFile
+  found := make(map[*FontFile]bool)
+  for _, family := range families {
+    for _, f := range l.FindFamily(family) {
+      if !found[f] {
+        found[f] = true
+        fonts = append(fonts, f)
+      }
+    }
+  }
+  return fonts
+}
+
+
+// Fonts returns all font files in the index
+//
+func (l *LocalFontIndex) Fonts() []*FontFile {
+  l.fontsmu.RLock()
+  defer l.fontsmu.RUnlock()
+  fonts := make([]*FontFile, len(l.fonts))
+  copy(fonts, l.fonts)
+  return fonts
+}
+
+
+// WriteTable writes a human-readable table of all fonts in l to w
+//
+func (l *LocalFontIndex) WriteTable(w io.Writer) error {
+  fonts := l.Fonts()
+  sort.Slice(fonts, func(i, j int) bool {
+    a, b := fonts[i], fonts[j]
+    if a.Family != b.Family {
+      return a.Family < b.Family
+    }
+    if a.Style != b.Style {
+      return a.Style < b.Style
+    }
+    return a.Filename < b.Filename
+  })
+  tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+  fmt.Fprintf(tw, "FAMILY\tSTYLE\tVERSION\tFILE\n")
+  for _, f := range fonts {
+    fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", f.Family, f.Style, f.Version.String(), f.Filename)
+  }
+  return tw.Flush()
+}
+
+
 func (l *LocalFontIndex) visitFile(dir string, file os.FileInfo) error {
   // Note: may run on different OS threads
 
@@ -45,9 +117,24 @@ func (l *LocalFontIndex) visitFile(dir string, file os.FileInfo) error {
     return nil
   }
 
-  f := &FontFile{}
+  if l.cache != nil {
+    if e := l.cache.Lookup(filename, file); e != nil {
+      if len(e.Error) > 0 {
+        L.Printf("\nfailed to parse %s: %s\n\n", filename, e.Error)
+        return nil
+      }
+      for _, f := range e.Fonts {
+        f := *f  // copy, so that the cache is not affected by changes
+        l.addFont(&f)
+      }
+      return nil
+    }
+  }
 
-  err := f.ParseFile(filename)
+  fonts, err := ParseFontFile(filename)
+  if l.cache != nil {
+    l.cache.Store(filename, file, fonts, err)
+  }
   if err != nil {
     L.Printf("\nfailed to parse %s: %s\n\n", filename, err)
     return nil
@@ -57,6 +144,14 @@ func (l *LocalFontIndex) visitFile(dir string, file os.FileInfo) error {
   //   L.Printf("+ \"%s\", \"%s\"\n", f.Family, f.Style)
   // }
 
+  for _, f := range fonts {
+    l.addFont(f)
+  }
+  return nil
+}
+
+
+func (l *LocalFontIndex) addFont(f *FontFile) {
   l.fontsmu.Lock()
   defer l.fontsmu.Unlock()
 
@@ -65,9 +160,13 @@ func (l *LocalFontIndex) visitFile(dir string, file os.FileInfo) error {
   if l.fontsByFamily == nil {
     l.fontsByFamily = make(map[string][]*FontFile)
   }
-  v, _ := l.fontsByFamily[f.Family]
-  l.fontsByFamily[f.Family] = append(v, f)
-
-  return nil
+  // index f under each of its localized family names
+  indexed := map[string]bool{}
+  for _, family := range append([]string{ f.Family }, f.FamilyNames()...) {
+    if !indexed[family] {
+      indexed[family] = true
+      l.fontsByFamily[family] = append(l.fontsByFamily[family], f)
+    }
+  }
 }
 
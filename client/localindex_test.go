@@ -0,0 +1,80 @@
+package main
+
+import (
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "testing"
+
+  "github.com/ConradIrwin/font/sfnt"
+)
+
+// makeTestFontDir writes n fonts to a new directory. Each font has a table
+// of padding bytes which is never read when indexing.
+//
+func makeTestFontDir(tb testing.TB, n, padding int) string {
+  dir, err := ioutil.TempDir("", "fontctrl-bench")
+  if err != nil {
+    tb.Fatal(err)
+  }
+  for i := 0; i < n; i++ {
+    family := fmt.Sprintf("Family %d", i / 8)
+    style := fmt.Sprintf("Style %d", i % 8)
+    data := makeTestSfnt(map[string][]byte{
+      "head": makeTestHead(1.25),
+      "name": makeTestName(map[sfnt.NameID]string{
+        sfnt.NameFontFamily:    family,
+        sfnt.NameFontSubfamily: style,
+        sfnt.NameVersion:       "Version 1.250",
+      }, nil),
+      "zzzz": nil,  // sorts last
+    })
+    filename := filepath.Join(dir, fmt.Sprintf("font-%d.otf", i))
+    if err := ioutil.WriteFile(filename, data, 0644); err != nil {
+      tb.Fatal(err)
+    }
+    // extend file with padding, which is sparse on most file systems
+    if err := os.Truncate(filename, int64(len(data) + padding)); err != nil {
+      tb.Fatal(err)
+    }
+  }
+  return dir
+}
+
+
+func TestScandir(t *testing.T) {
+  dir := makeTestFontDir(t, 64, 1024)
+  defer os.RemoveAll(dir)
+
+  local := NewLocalFontIndex(nil)
+  if err := local.Scandir(dir); err != nil {
+    t.Fatal(err)
+  }
+  if n := len(local.Fonts()); n != 64 {
+    t.Errorf("indexed %d fonts; expected 64", n)
+  }
+  if fonts := local.FindFamily("Family 7"); len(fonts) != 8 {
+    t.Errorf("found %d fonts of \"Family 7\"; expected 8", len(fonts))
+  }
+}
+
+
+func BenchmarkScandir(b *testing.B) {
+  for _, padding := range []int{ 1 << 10, 1 << 20 } {
+    b.Run(fmt.Sprintf("3000x%dKB", padding >> 10), func(b *testing.B) {
+      dir := makeTestFontDir(b, 3000, padding)
+      defer os.RemoveAll(dir)
+      b.ResetTimer()
+      for i := 0; i < b.N; i++ {
+        local := NewLocalFontIndex(nil)
+        if err := local.Scandir(dir); err != nil {
+          b.Fatal(err)
+        }
+        if n := len(local.Fonts()); n != 3000 {
+          b.Fatalf("indexed %d fonts; expected 3000", n)
+        }
+      }
+    })
+  }
+}
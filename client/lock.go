@@ -0,0 +1,173 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "path/filepath"
+  "strings"
+)
+
+const lockFileName = "fontctrl.lock"
+
+// LockEntry records exactly what was installed for a font
+//
+type LockEntry struct {
+  Version    *Version `json:"version"`
+  Repo       string   `json:"repo"`  // url of the repo, without credentials
+  ArchiveUrl string   `json:"archive_url"`  // without credentials
+  Checksum   string   `json:"checksum"`  // SHA-1 of the archive
+}
+
+// Lockfile corresponds to fontctrl.lock, which is written next to the
+// config file by "sync" so that a team can install identical font sets
+// with "sync -frozen"
+//
+type Lockfile struct {
+  Fonts map[string]*LockEntry `json:"fonts"`
+
+  File string `json:"-"`
+}
+
+
+// LockFile returns the path of the lock file belonging to c, or an empty
+// string if c was not loaded from a file
+//
+func (c *Config) LockFile() string {
+  if len(c.File) == 0 || c.File == "<builtin>" {
+    return ""
+  }
+  return filepath.Join(filepath.Dir(c.File), lockFileName)
+}
+
+
+// LoadLockfile reads a lock file
+//
+func LoadLockfile(filename string) (*Lockfile, error) {
+  data, err := ioutil.ReadFile(filename)
+  if err != nil {
+    return nil, err
+  }
+  l := &Lockfile{ File: filename }
+  if err := json.Unmarshal(data, l); err != nil {
+    return nil, fmt.Errorf("%s: %v", filename, err)
+  }
+  if l.Fonts == nil {
+    l.Fonts = make(map[string]*LockEntry)
+  }
+  return l, nil
+}
+
+
+// Save writes l to l.File
+//
+func (l *Lockfile) Save() error {
+  data, err := json.MarshalIndent(l, "", "  ")
+  if err != nil {
+    return err
+  }
+  return writeFileAtomic(l.File, append(data, '\n'), 0644)
+}
+
+
+// lockfileFromPlan creates a lock file describing the versions resolved in p
+//
+func lockfileFromPlan(filename string, p *Plan) (*Lockfile, error) {
+  l := &Lockfile{ File: filename, Fonts: make(map[string]*LockEntry) }
+  for _, fp := range p.Fonts {
+    if len(fp.Error) > 0 {
+      continue
+    }
+    url, err := fp.Index.GetArchiveUrlAt(fp.VersionIndex, fp.Info)
+    if err != nil {
+      return nil, err
+    }
+    l.Fonts[fp.Font] = &LockEntry{
+      Version:    fp.Version,
+      Repo:       stripURLUserinfo(fp.Index.Repo.Url),
+      ArchiveUrl: stripURLUserinfo(url),
+      Checksum:   strings.ToLower(strings.TrimSpace(fp.Info.Checksum)),
+    }
+  }
+  return l, nil
+}
+
+
+// Resolve finds the locked version of the font fid in the repos of c.
+// Returns an error if the font is not locked, the locked version no longer
+// matches pattern, or the repo no longer provides the locked version.
+//
+func (l *Lockfile) Resolve(
+  c *Config,
+  fid string,
+  pattern *VersionPattern,
+) (*FontIndex, int, error) {
+  e := l.Fonts[fid]
+  if e == nil || e.Version == nil {
+    return nil, -1, fmt.Errorf("not in %s", l.File)
+  }
+  if !pattern.Matches(e.Version) {
+    return nil, -1, fmt.Errorf(
+      "locked version %s does not match %s; lock file is out of date",
+      e.Version, pattern.String())
+  }
+
+  var repo *Repo
+  for _, r := range c.Repos {
+    if stripURLUserinfo(r.Url) == stripURLUserinfo(e.Repo) {
+      repo = r
+      break
+    }
+  }
+  if repo == nil {
+    return nil, -1, fmt.Errorf("locked repo %s is not configured", redactURL(e.Repo))
+  }
+
+  findex := repo.Index.Fonts[fid]
+  if findex == nil {
+    return nil, -1, fmt.Errorf("not found in locked repo %s", redactURL(e.Repo))
+  }
+  for i, v := range findex.Versions {
+    if v.String() == e.Version.String() {
+      return findex, i, nil
+    }
+  }
+  return nil, -1, fmt.Errorf(
+    "locked version %s not found in repo %s", e.Version, redactURL(e.Repo))
+}
+
+
+// Verify checks that the archive of version i of findex is the one that
+// was locked
+//
+func (l *Lockfile) Verify(fid string, findex *FontIndex, i int, fvi *FontVersionInfo) error {
+  e := l.Fonts[fid]
+  url, err := findex.GetArchiveUrlAt(i, fvi)
+  if err != nil {
+    return err
+  }
+  if stripURLUserinfo(url) != stripURLUserinfo(e.ArchiveUrl) {
+    return fmt.Errorf("archive url changed from %s to %s",
+      redactURL(e.ArchiveUrl), redactURL(url))
+  }
+  if !strings.EqualFold(strings.TrimSpace(fvi.Checksum), e.Checksum) {
+    return fmt.Errorf("checksum of %s changed from %s to %s",
+      redactURL(url), e.Checksum, fvi.Checksum)
+  }
+  return nil
+}
+
+
+// CheckSubscriptions returns an error if l contains fonts that are not
+// subscribed to in c
+//
+func (l *Lockfile) CheckSubscriptions(c *Config) error {
+  for fid := range l.Fonts {
+    if _, ok := c.Fonts[fid]; !ok {
+      return fmt.Errorf(
+        "%s contains \"%s\" which is not in %s; lock file is out of date",
+        l.File, fid, c.File)
+    }
+  }
+  return nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+  "encoding/json"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+func TestLockfileVerifyRedacted(t *testing.T) {
+  const password = "s3cr3t-passw0rd"
+  const archiveUrl = "https://fonts:" + password + "@fonts.example.com/inter.zip"
+  ver, _ := ParseVersion("3.19")
+  l := &Lockfile{ File: "fontctrl.lock", Fonts: map[string]*LockEntry{
+    "inter": {
+      Version:    ver,
+      Repo:       "https://fonts:" + password + "@fonts.example.com/",
+      ArchiveUrl: archiveUrl,
+      Checksum:   "aaaa",
+    },
+  }}
+  findex := &FontIndex{ Id: "inter", Versions: []*Version{ ver } }
+  infos := []*FontVersionInfo{
+    { ArchiveUrl: archiveUrl, Checksum: "bbbb" },  // checksum changed
+    { ArchiveUrl: archiveUrl + "?v=2", Checksum: "aaaa" },  // url changed
+  }
+  for _, fvi := range infos {
+    err := l.Verify("inter", findex, 0, fvi)
+    if err == nil {
+      t.Errorf("expected error for %+v", fvi)
+    } else if strings.Contains(err.Error(), password) {
+      t.Errorf("password in error: %v", err)
+    }
+  }
+}
+
+
+func TestLockfileWithoutCredentials(t *testing.T) {
+  const password = "s3cr3t-passw0rd"
+  const token = "s3cr3t-t0k3n"
+  ver, _ := ParseVersion("3.19")
+  repo := &Repo{ Url: "https://fonts:" + password + "@fonts.example.com/" }
+  findex := &FontIndex{ Repo: repo, Id: "inter", Versions: []*Version{ ver } }
+  repo.Index.Fonts = map[string]*FontIndex{ "inter": findex }
+  fvi := &FontVersionInfo{
+    ArchiveUrl: "https://" + token + "@cdn.example.com/inter.zip",
+    Checksum:   "abc",
+  }
+  plan := &Plan{ Fonts: []*FontPlan{
+    { Font: "inter", Version: ver, Index: findex, VersionIndex: 0, Info: fvi },
+  }}
+
+  l, err := lockfileFromPlan("fontctrl.lock", plan)
+  if err != nil {
+    t.Fatal(err)
+  }
+  data, _ := json.Marshal(l)
+  if strings.Contains(string(data), password) || strings.Contains(string(data), token) {
+    t.Errorf("credentials written to lock file: %s", data)
+  }
+  e := l.Fonts["inter"]
+  if e.Repo != "https://fonts.example.com/" ||
+     e.ArchiveUrl != "https://cdn.example.com/inter.zip" {
+    t.Errorf("unexpected lock entry %+v", e)
+  }
+
+  // the lock file still matches the repo configured with credentials
+  c := &Config{ Repos: []*Repo{ repo } }
+  pattern := VersionPattern{}
+  pattern.Parse("3")
+  if _, _, err := l.Resolve(c, "inter", &pattern); err != nil {
+    t.Error(err)
+  }
+  if err := l.Verify("inter", findex, 0, fvi); err != nil {
+    t.Error(err)
+  }
+}
+
+
+func TestLockfile(t *testing.T) {
+  tmpdir, err := ioutil.TempDir("", "fontctrl-test")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(tmpdir)
+
+  const index = `{"fonts":{"inter":{"name":"Inter","versions":["3.19","3.18"]}}}`
+  const info = `{"version":"3.19","checksum":"ABC","name":"Inter","styles":["Regular"]}`
+  const config = "repos:\n  - url: ./repo\nfonts:\n  inter: \"3\"\n"
+  repodir := filepath.Join(tmpdir, "repo")
+  configFile := filepath.Join(tmpdir, "fontctrl.yml")
+
+  load := func(config, index, info string) *Config {
+    writeTestFile(t, filepath.Join(repodir, "index.json"), index)
+    writeTestFile(t, filepath.Join(repodir, "inter", "inter-3.19.json"), info)
+    writeTestFile(t, configFile, config)
+    c := &Config{}
+    if err := c.LoadFile(configFile); err != nil {
+      t.Fatal(err)
+    }
+    if err := c.Repos[0].Update(); err != nil {
+      t.Fatal(err)
+    }
+    return c
+  }
+
+  // write a lock file from a plan and read it back
+  c := load(config, index, info)
+  plan, err := computePlan(c, NewLocalFontIndex(nil), &ReceiptDB{}, nil)
+  if err != nil {
+    t.Fatal(err)
+  }
+  lock, err := lockfileFromPlan(c.LockFile(), plan)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if err := lock.Save(); err != nil {
+    t.Fatal(err)
+  }
+  lock, err = LoadLockfile(filepath.Join(tmpdir, lockFileName))
+  if err != nil {
+    t.Fatal(err)
+  }
+  e := lock.Fonts["inter"]
+  if len(lock.Fonts) != 1 || e == nil || e.Version.String() != "3.19" ||
+     e.Repo != "./repo" || e.Checksum != "abc" ||
+     e.ArchiveUrl != "inter/inter-3.19.zip" {
+    t.Fatalf("unexpected lock file %+v", e)
+  }
+
+  // -frozen installs the locked version
+  plan, err = computePlan(c, NewLocalFontIndex(nil), &ReceiptDB{}, lock)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if fp := plan.Fonts[0]; len(fp.Error) > 0 || fp.Version.String() != "3.19" {
+    t.Errorf("frozen plan: %+v", fp)
+  }
+
+  // the lock file is valid in another checkout of the same repo
+  tmpdir2, err := ioutil.TempDir("", "fontctrl-test")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(tmpdir2)
+  writeTestFile(t, filepath.Join(tmpdir2, "fontctrl.yml"), config)
+  if err := os.Rename(repodir, filepath.Join(tmpdir2, "repo")); err != nil {
+    t.Fatal(err)
+  }
+  c2 := &Config{}
+  if err := c2.LoadFile(filepath.Join(tmpdir2, "fontctrl.yml")); err != nil {
+    t.Fatal(err)
+  }
+  if err := c2.Repos[0].Update(); err != nil {
+    t.Fatal(err)
+  }
+  plan, err = computePlan(c2, NewLocalFontIndex(nil), &ReceiptDB{}, lock)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if fp := plan.Fonts[0]; len(fp.Error) > 0 {
+    t.Errorf("lock file not valid in another checkout: %s", fp.Error)
+  }
+
+  // -frozen fails when the lock file no longer can be honored
+  tests := []struct {
+    name   string
+    config string
+    index  string
+    info   string
+    err    string
+  }{
+    { "pattern changed",
+      "repos:\n  - url: ./repo\nfonts:\n  inter: \"3.18\"\n", index, info,
+      "does not match" },
+    { "version gone",
+      config, `{"fonts":{"inter":{"name":"Inter","versions":["3.18"]}}}`, info,
+      "not found in repo" },
+    { "font gone",
+      config, `{"fonts":{}}`, info,
+      "not found in locked repo" },
+    { "checksum changed",
+      config, index, strings.Replace(info, "ABC", "abd", 1),
+      "checksum" },
+    { "archive url changed",
+      config, index, strings.Replace(info, "{", `{"archive_url":"x.zip",`, 1),
+      "archive url changed" },
+    { "repo not configured",
+      "repos:\n  - url: ./repo/\nfonts:\n  inter: \"3\"\n", index, info,
+      "not configured" },
+    { "subscription not locked",
+      config + "  roboto: \"*\"\n", index, info,
+      "not in " + lock.File },
+  }
+  for _, test := range tests {
+    c := load(test.config, test.index, test.info)
+    plan, err := computePlan(c, NewLocalFontIndex(nil), &ReceiptDB{}, lock)
+    if err != nil {
+      t.Fatal(err)
+    }
+    failed := false
+    for _, fp := range plan.Fonts {
+      if strings.Contains(fp.Error, test.err) {
+        failed = true
+      }
+    }
+    if !failed || !plan.HasErrors() {
+      t.Errorf("%s: expected error %q; got %+v", test.name, test.err, plan.Fonts[0])
+    }
+  }
+
+  // fonts in the lock file which are no longer subscribed to
+  c = load("repos:\n  - url: ./repo\nfonts: {}\n", index, info)
+  if err := lock.CheckSubscriptions(c); err == nil {
+    t.Errorf("expected error for unsubscribed font in lock file")
+  }
+  c = load(config, index, info)
+  if err := lock.CheckSubscriptions(c); err != nil {
+    t.Error(err)
+  }
+}
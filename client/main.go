@@ -18,8 +18,8 @@ var config Config
 
 func init() {
   progname = os.Args[0]
-  L = log.New(os.Stdout, "", log.Ltime)
-  // L = log.New(os.Stdout, "", log.Ldate | log.Ltime | log.LUTC)
+  L = log.New(os.Stderr, "", log.Ltime)
+  // L = log.New(os.Stderr, "", log.Ldate | log.Ltime | log.LUTC)
 }
 
 
@@ -33,17 +33,24 @@ func updateRepos() {
 }
 
 
-func cmd_sync(args []string) {
-  if len(args) > 0 {
-    L.Fatalf("'%s sync' does not accept any arguments\n", progname)
-  }
-  // opt := flag.NewFlagSet(progname + " sync", flag.ExitOnError)
-  // opt.Parse(args)
+// exit status of "sync -dry-run" when there are pending changes
+const exitChangesPending = 2
 
-  updateRepos()
 
+// scanLocalFonts indexes the fonts in config.FontDir. Fonts which haven't
+// changed since the last scan are read from the index cache, unless rebuild
+// is true.
+//
+func scanLocalFonts(rebuild bool) *LocalFontIndex {
   L.Printf("scanning fonts in %s\n", config.FontDir)
-  var local LocalFontIndex
+  var cache *IndexCache
+  if filename := indexCacheFile(); len(filename) > 0 {
+    cache = LoadIndexCache(filename)
+    if rebuild {
+      cache.Reset()
+    }
+  }
+  local := NewLocalFontIndex(cache)
   if err := local.Scandir(config.FontDir); err != nil {
     if pe, ok := err.(*os.PathError); ok && pe != nil {
       // not found -- continue
@@ -51,39 +58,271 @@ func cmd_sync(args []string) {
       L.Fatal(err)
     }
   }
+  if cache != nil {
+    if err := cache.Save(); err != nil {
+      L.Printf("failed to write index cache: %v", err)
+    }
+  }
+  return local
+}
 
-  for fid, fsub := range config.Fonts {
-    findex := config.FindFontIndex(fid)
-    if findex == nil {
-      L.Printf("error: font \"%s\" not found in any repository\n", fid)
-      continue
+
+func cmd_sync(args []string) {
+  opt := flag.NewFlagSet(progname + " sync", flag.ExitOnError)
+  dryRun := opt.Bool("dry-run", false,
+    "Print what would change and exit; exits with status 2 if changes are pending")
+  jsonOutput := opt.Bool("json", false, "Print the plan as JSON; implies -dry-run")
+  prune := opt.Bool("prune", false,
+    "Remove fonts installed by fontctrl which are no longer subscribed to")
+  yes := opt.Bool("yes", false, "Don't ask for confirmation before pruning")
+  frozen := opt.Bool("frozen", false,
+    "Install exactly the versions in " + lockFileName + " and fail if that's not possible")
+  opt.Parse(args)
+  if opt.NArg() > 0 {
+    L.Fatalf("'%s sync' does not accept any arguments\n", progname)
+  }
+  if *jsonOutput {
+    *dryRun = true
+  }
+
+  if !*dryRun {
+    if err := RecoverTxn(config.FontDir); err != nil {
+      L.Fatal(err)
     }
+  }
 
-    L.Printf("found %s (%s) => %+v in repo %s\n",
-      fid, fsub.VersionPattern.String(), findex, findex.Repo)
+  receipts, err := LoadReceipts(config.FontDir)
+  if err != nil {
+    L.Fatal(err)
+  }
 
-    // matching version
-    i, latever := fsub.VersionPattern.Match(findex.Versions)
-    if i == -1 {
-      L.Printf("no matching version for %s\n", fid)
-      continue
+  var lock *Lockfile
+  if *frozen {
+    if len(config.LockFile()) == 0 {
+      L.Fatalf("-frozen requires a config file\n")
     }
+    if lock, err = LoadLockfile(config.LockFile()); err != nil {
+      L.Fatal(err)
+    }
+    if err := lock.CheckSubscriptions(&config); err != nil {
+      L.Fatal(err)
+    }
+  }
 
-    L.Printf("latest version for %s => %s\n", fid, latever)
+  updateRepos()
+  local := scanLocalFonts(false)
 
-    // get font info
-    finfo, err := findex.GetVersionInfoAt(i)
+  plan, err := computePlan(&config, local, receipts, lock)
+  if err != nil {
+    L.Fatal(err)
+  }
+  if *prune {
+    plan.Orphans = findOrphans(&config, local, receipts)
+  }
+
+  if *dryRun {
+    if *jsonOutput {
+      err = plan.WriteJSON(os.Stdout)
+    } else {
+      err = plan.WriteTable(os.Stdout)
+    }
     if err != nil {
       L.Fatal(err)
     }
-    L.Printf("findex.GetInfo() => %+v\n", finfo)
+    if plan.HasErrors() {
+      os.Exit(1)
+    }
+    if plan.HasChanges() {
+      os.Exit(exitChangesPending)
+    }
+    return
+  }
+
+  if *frozen && plan.HasErrors() {
+    for _, fp := range plan.Fonts {
+      if len(fp.Error) > 0 {
+        L.Printf("error: %s: %s\n", fp.Font, fp.Error)
+      }
+    }
+    L.Fatalf("unable to install the fonts in %s\n", lock.File)
+  }
 
-    // find local
-    locals := local.FindFamily(findex.Family)
-    for _, lf := range locals {
-      L.Printf("local font: %+v\n", lf.Style)
+  failures := 0
+
+  // download and verify all archives before installing anything, so that
+  // -frozen doesn't install some fonts when others can't be
+  archives := make(map[*FontPlan]string)
+  removeArchives := func() {
+    for _, archive := range archives {
+      os.Remove(archive)
+    }
+  }
+  for _, fp := range plan.Fonts {
+    if len(fp.Error) > 0 {
+      L.Printf("error: %s: %s\n", fp.Font, fp.Error)
+      failures++
+      continue
+    }
+    if !fp.HasChanges() {
+      L.Printf("%s is up to date (%s)\n", fp.Font, fp.Version)
+      continue
+    }
+    archive, err := downloadFontVersion(fp.Index, fp.VersionIndex, fp.Info)
+    if err != nil {
+      L.Printf("error: failed to download %s %s: %v\n", fp.Font, fp.Version, err)
+      failures++
+      continue
+    }
+    archives[fp] = archive
+  }
+  if *frozen && failures > 0 {
+    removeArchives()
+    L.Fatalf("unable to install the fonts in %s\n", lock.File)
+  }
+
+  for _, fp := range plan.Fonts {
+    archive, ok := archives[fp]
+    if !ok {
+      continue
+    }
+    L.Printf("installing %s %s\n", fp.Font, fp.Version)
+    err := installFontVersion(
+      config.FontDir, fp.Index, fp.VersionIndex, fp.Info, fp.Locals, archive)
+    if err != nil {
+      L.Printf("error: failed to install %s %s: %v\n", fp.Font, fp.Version, err)
+      failures++
     }
   }
+  removeArchives()
+
+  if !*frozen && failures == 0 && len(config.LockFile()) > 0 {
+    lock, err := lockfileFromPlan(config.LockFile(), plan)
+    if err == nil {
+      err = lock.Save()
+    }
+    if err != nil {
+      L.Printf("error: failed to write %s: %v\n", config.LockFile(), err)
+      failures++
+    }
+  }
+
+  if *prune && failures == 0 {
+    // installing may have orphaned files of renamed families
+    doPrune(*yes)
+  }
+
+  if failures > 0 {
+    os.Exit(1)
+  }
+}
+
+
+func cmd_prune(args []string) {
+  opt := flag.NewFlagSet(progname + " prune", flag.ExitOnError)
+  yes := opt.Bool("yes", false, "Don't ask for confirmation")
+  opt.Parse(args)
+  if opt.NArg() > 0 {
+    L.Fatalf("'%s prune' does not accept any arguments\n", progname)
+  }
+  if err := RecoverTxn(config.FontDir); err != nil {
+    L.Fatal(err)
+  }
+  updateRepos()
+  doPrune(*yes)
+}
+
+
+func doPrune(yes bool) {
+  receipts, err := LoadReceipts(config.FontDir)
+  if err != nil {
+    L.Fatal(err)
+  }
+  local := scanLocalFonts(false)
+  orphans := findOrphans(&config, local, receipts)
+  if len(orphans) == 0 {
+    L.Printf("nothing to prune\n")
+    return
+  }
+  ok, err := pruneOrphans(config.FontDir, receipts, orphans, yes)
+  if err != nil {
+    L.Fatal(err)
+  }
+  if !ok {
+    os.Exit(1)
+  }
+}
+
+
+func cmd_uninstall(args []string) {
+  opt := flag.NewFlagSet(progname + " uninstall", flag.ExitOnError)
+  opt.Parse(args)
+  if opt.NArg() != 1 {
+    L.Fatalf("usage: %s uninstall <font>\n", progname)
+  }
+  fid := opt.Arg(0)
+
+  if err := RecoverTxn(config.FontDir); err != nil {
+    L.Fatal(err)
+  }
+  receipts, err := LoadReceipts(config.FontDir)
+  if err != nil {
+    L.Fatal(err)
+  }
+
+  managed := receipts.FontFiles(fid)
+  if _, ok := config.Fonts[fid]; !ok && len(managed) == 0 {
+    L.Fatalf("font \"%s\" is not installed\n", fid)
+  }
+
+  // find families of the font's files, so we can tell the user about files
+  // of the same family which fontctrl didn't install
+  local := scanLocalFonts(false)
+  families := make(map[string]struct{})
+  for _, f := range local.Fonts() {
+    if r := receipts.Get(f.Filename); r != nil && r.Font == fid {
+      families[f.Family] = struct{}{}
+    }
+  }
+
+  removed, kept, err := uninstallFont(config.FontDir, receipts, fid)
+  if err != nil {
+    L.Fatal(err)
+  }
+  for _, filename := range removed {
+    fmt.Printf("removed %s\n", filename)
+  }
+  for _, filename := range kept {
+    fmt.Printf("left %s (modified since it was installed)\n", filename)
+  }
+  left := make(map[string]struct{})
+  for _, f := range local.Fonts() {
+    if _, ok := left[f.Filename]; ok {
+      continue  // another face of a font collection
+    }
+    if _, ok := families[f.Family]; ok && receipts.Get(f.Filename) == nil {
+      fmt.Printf("left %s (not installed by fontctrl)\n", f.Filename)
+      left[f.Filename] = struct{}{}
+    }
+  }
+
+  if err := config.RemoveFont(fid); err != nil {
+    L.Fatal(err)
+  }
+}
+
+
+func cmd_scan(args []string) {
+  opt := flag.NewFlagSet(progname + " scan", flag.ExitOnError)
+  rebuild := opt.Bool("rebuild", false,
+    "Parse all fonts again instead of using the index cache")
+  opt.Parse(args)
+  if opt.NArg() > 0 {
+    L.Fatalf("'%s scan' does not accept any arguments\n", progname)
+  }
+  local := scanLocalFonts(*rebuild)
+  if err := local.WriteTable(os.Stdout); err != nil {
+    L.Fatal(err)
+  }
 }
 
 
@@ -101,14 +340,20 @@ func main() {
   flag.Usage = func() {
     fmt.Fprintf(os.Stderr, "Usage: %s [options] <command>\n", progname)
     fmt.Fprintf(os.Stderr, "\nCommands:\n")
-    fmt.Fprintf(os.Stderr, "  sync     Sync repositories and update fonts\n")
-    fmt.Fprintf(os.Stderr, "  version  Print version and exit\n")
+    fmt.Fprintf(os.Stderr, "  sync              Sync repositories and update fonts\n")
+    fmt.Fprintf(os.Stderr, "  prune             Remove fonts which are no longer subscribed to\n")
+    fmt.Fprintf(os.Stderr, "  uninstall <font>  Remove a font and unsubscribe from it\n")
+    fmt.Fprintf(os.Stderr, "  scan              List fonts installed in the font directory\n")
+    fmt.Fprintf(os.Stderr, "  version           Print version and exit\n")
     fmt.Fprintf(os.Stderr, "\nOptions:\n")
     flag.PrintDefaults()
   }
   var configFile string
   flag.StringVar(&configFile, "config", "", "Config file")
+  flag.BoolVar(&httpCache.Offline, "offline", false,
+    "Don't access the network; use cached repo metadata")
   flag.Parse()
+  httpCache.Dir = httpCacheDir()
 
   if flag.NArg() == 0 { // no <command>
     flag.Usage()
@@ -132,8 +377,11 @@ func main() {
   args := flag.Args()[1:]
   
   switch cmd {
-    case "sync":    cmd_sync(args)
-    case "version": cmd_version(args)
+    case "sync":      cmd_sync(args)
+    case "prune":     cmd_prune(args)
+    case "uninstall": cmd_uninstall(args)
+    case "scan":      cmd_scan(args)
+    case "version":   cmd_version(args)
     default:
       L.Fatalf("Unknown command %s\nSee %s -h for help\n", cmd, progname)
   }
@@ -0,0 +1,391 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "io"
+  "sort"
+  "strings"
+  "text/tabwriter"
+)
+
+type PlanAction int
+
+const (
+  PlanNoop = PlanAction(iota)
+  PlanInstall    // style is not installed locally
+  PlanUpgrade    // local style is older than the repo version
+  PlanDowngrade  // local style is newer than the repo version
+  PlanRemove     // local style is not part of the repo version
+  PlanSkip       // local style is not managed by fontctrl and is left alone
+)
+
+func (a PlanAction) String() string {
+  switch a {
+    case PlanNoop:      return "none"
+    case PlanInstall:   return "install"
+    case PlanUpgrade:   return "upgrade"
+    case PlanDowngrade: return "downgrade"
+    case PlanRemove:    return "remove"
+    case PlanSkip:      return "skip"
+  }
+  return fmt.Sprintf("PlanAction(%d)", int(a))
+}
+
+func (a PlanAction) MarshalJSON() ([]byte, error) {
+  return json.Marshal(a.String())
+}
+
+
+// PlanItem describes what will happen to a single style of a font
+//
+type PlanItem struct {
+  Style     string     `json:"style"`
+  Action    PlanAction `json:"action"`
+  From      *Version   `json:"from,omitempty"`  // local version
+  To        *Version   `json:"to,omitempty"`    // repo version
+  Filename  string     `json:"filename,omitempty"`   // local file
+  Unmanaged bool       `json:"unmanaged,omitempty"`  // local file has no receipt
+}
+
+// FontPlan describes what will happen to a subscribed font
+//
+type FontPlan struct {
+  Font    string      `json:"font"`  // font id
+  Family  string      `json:"family,omitempty"`
+  Version *Version    `json:"version,omitempty"`  // resolved repo version
+  Items   []*PlanItem `json:"styles"`
+  Error   string      `json:"error,omitempty"`
+
+  Index        *FontIndex       `json:"-"`
+  VersionIndex int              `json:"-"`  // index into Index.Versions
+  Info         *FontVersionInfo `json:"-"`
+  Locals       []*FontFile      `json:"-"`  // installed files of Family or aliases
+}
+
+// Plan describes what a sync would do
+//
+type Plan struct {
+  Fonts   []*FontPlan `json:"fonts"`
+  Orphans []*Orphan   `json:"orphans,omitempty"`  // files to be pruned
+}
+
+
+// HasChanges returns true if applying fp would modify any local files
+//
+func (fp *FontPlan) HasChanges() bool {
+  for _, it := range fp.Items {
+    if it.Action != PlanNoop && it.Action != PlanSkip {
+      return true
+    }
+  }
+  return false
+}
+
+// HasChanges returns true if applying p would modify any local files
+//
+func (p *Plan) HasChanges() bool {
+  if len(p.Orphans) > 0 {
+    return true
+  }
+  for _, fp := range p.Fonts {
+    if fp.HasChanges() {
+      return true
+    }
+  }
+  return false
+}
+
+// HasErrors returns true if any font in p could not be planned
+//
+func (p *Plan) HasErrors() bool {
+  for _, fp := range p.Fonts {
+    if len(fp.Error) > 0 {
+      return true
+    }
+  }
+  return false
+}
+
+
+// computePlan compares the fonts subscribed to in c with the fonts in local
+// and returns a plan of what needs to change. Repos must be updated.
+// Local files without a receipt in receipts are never removed.
+// When lock is non-nil, versions are taken from lock rather than resolved.
+//
+func computePlan(
+  c *Config,
+  local *LocalFontIndex,
+  receipts *ReceiptDB,
+  lock *Lockfile,
+) (*Plan, error) {
+  p := &Plan{}
+
+  // stable order
+  fids := make([]string, 0, len(c.Fonts))
+  for fid := range c.Fonts {
+    fids = append(fids, fid)
+  }
+  sort.Strings(fids)
+
+  for _, fid := range fids {
+    fsub := c.Fonts[fid]
+    fp := &FontPlan{ Font: fid, VersionIndex: -1 }
+    p.Fonts = append(p.Fonts, fp)
+
+    var findex *FontIndex
+    var i int
+    if lock != nil {
+      var err error
+      findex, i, err = lock.Resolve(c, fid, &fsub.VersionPattern)
+      if err != nil {
+        fp.Error = err.Error()
+        continue
+      }
+    } else {
+      findex = c.FindFontIndex(fid)
+      if findex == nil {
+        fp.Error = "not found in any repository"
+        continue
+      }
+      i, _ = fsub.VersionPattern.Match(findex.Versions)
+      if i == -1 {
+        fp.Error = fmt.Sprintf("no version matching %s", fsub.VersionPattern.String())
+        continue
+      }
+    }
+    ver := findex.Versions[i]
+    fp.Index = findex
+    fp.Family = findex.Family
+    fp.VersionIndex = i
+    fp.Version = ver
+
+    finfo, err := findex.GetVersionInfoAt(i)
+    if err != nil {
+      fp.Error = err.Error()
+      continue
+    }
+    fp.Info = finfo
+
+    if lock != nil {
+      if err := lock.Verify(fid, findex, i, finfo); err != nil {
+        fp.Error = err.Error()
+        continue
+      }
+    }
+
+    fp.Locals = local.FindFamilies(c.FontFamilies(fid, findex, finfo))
+    fp.Items = planStyles(finfo.Styles, ver, fp.Locals)
+    planFromReceipts(fp.Items, findex.Versions, i, receipts)
+    skipUnmanaged(fp.Items, receipts)
+  }
+
+  return p, nil
+}
+
+
+// planFromReceipts revises the actions of items when the repo version
+// versions[i] can't be compared to the versions of local files, which are
+// always parsed as SemVer. That is the case for opaque versions like "r12",
+// which would otherwise look like 0.0.0, and for versions of other kinds.
+// Instead, the repo version recorded in the receipt of a file is compared
+// to versions[i] by position in versions (latest first). Files of opaque
+// versions without a receipt, or whose recorded version is no longer in the
+// repo, are left as they are.
+//
+func planFromReceipts(items []*PlanItem, versions []*Version, i int, receipts *ReceiptDB) {
+  ver := versions[i]
+  if ver.Kind == VersionSemVer {
+    return
+  }
+  for _, it := range items {
+    if len(it.Filename) == 0 || it.To == nil || it.Action == PlanRemove {
+      continue
+    }
+    ri := -1
+    if r := receipts.Get(it.Filename); r != nil && r.Version != nil {
+      for j, v := range versions {
+        if v.String() == r.Version.String() {
+          ri = j
+          break
+        }
+      }
+    }
+    switch {
+    case ri == -1:
+      if ver.Kind == VersionOpaque {
+        it.Action = PlanNoop  // unknown
+      }
+    case ri > i: it.Action = PlanUpgrade
+    case ri < i: it.Action = PlanDowngrade
+    default:     it.Action = PlanNoop
+    }
+  }
+}
+
+
+// skipUnmanaged marks items for local files which fontctrl didn't install.
+// installFontVersion never replaces or removes such files, so items which
+// would change them are skipped.
+//
+func skipUnmanaged(items []*PlanItem, receipts *ReceiptDB) {
+  for _, it := range items {
+    if len(it.Filename) > 0 && !receipts.IsManaged(it.Filename) {
+      it.Unmanaged = true
+      if it.Action != PlanNoop {
+        it.Action = PlanSkip
+      }
+    }
+  }
+}
+
+
+// planStyles compares the styles of a repo version to installed fonts
+//
+func planStyles(styles []string, ver *Version, locals []*FontFile) []*PlanItem {
+  var items []*PlanItem
+
+  if len(styles) == 0 {
+    // repo doesn't list styles; compare against whatever is installed
+    if len(locals) == 0 {
+      return []*PlanItem{ &PlanItem{ Action: PlanInstall, To: ver } }
+    }
+    for _, lf := range locals {
+      items = append(items, planStyle(lf.Style, ver, lf))
+    }
+    return unifyCollectionItems(items)
+  }
+
+  matched := make(map[*FontFile]struct{}, len(locals))
+
+  for _, style := range styles {
+    var lf *FontFile
+    for _, f := range locals {
+      if strings.EqualFold(f.Style, style) {
+        lf = f
+        matched[f] = struct{}{}
+        break
+      }
+    }
+    items = append(items, planStyle(style, ver, lf))
+  }
+
+  for _, lf := range locals {
+    if _, ok := matched[lf]; !ok {
+      items = append(items, &PlanItem{
+        Style:    lf.Style,
+        Action:   PlanRemove,
+        From:     &lf.Version,
+        Filename: lf.Filename,
+      })
+    }
+  }
+
+  return unifyCollectionItems(items)
+}
+
+
+// unifyCollectionItems makes the items for faces of the same font collection
+// agree, since a collection file can only be replaced or removed as a whole:
+// when any face is upgraded or downgraded, so are all other faces, and a face
+// is not removed while other faces of the file are kept.
+//
+func unifyCollectionItems(items []*PlanItem) []*PlanItem {
+  byFile := make(map[string][]*PlanItem)
+  for _, it := range items {
+    if len(it.Filename) > 0 {
+      byFile[it.Filename] = append(byFile[it.Filename], it)
+    }
+  }
+  for _, faces := range byFile {
+    if len(faces) < 2 {
+      continue
+    }
+    replace := PlanNoop
+    kept := false
+    for _, it := range faces {
+      switch it.Action {
+        case PlanUpgrade, PlanDowngrade:
+          if replace == PlanNoop {
+            replace = it.Action
+          }
+        case PlanNoop:
+          kept = true
+      }
+    }
+    for _, it := range faces {
+      if replace != PlanNoop && it.Action == PlanNoop {
+        it.Action = replace
+      } else if replace == PlanNoop && kept && it.Action == PlanRemove {
+        it.Action = PlanNoop
+      }
+    }
+  }
+  return items
+}
+
+
+func planStyle(style string, ver *Version, lf *FontFile) *PlanItem {
+  it := &PlanItem{ Style: style, To: ver, Action: PlanInstall }
+  if lf == nil {
+    return it
+  }
+  it.From = &lf.Version
+  it.Filename = lf.Filename
+  // versions of font files are always parsed as SemVer, so compare them by
+  // numbers rather than by kind, e.g. "2024.5.1" == "2024.05.01"
+  switch lf.Version.compareSemVer(ver) {
+    case -1: it.Action = PlanUpgrade
+    case  1: it.Action = PlanDowngrade
+    default: it.Action = PlanNoop
+  }
+  return it
+}
+
+
+// WriteTable writes a human-readable table describing p to w
+//
+func (p *Plan) WriteTable(w io.Writer) error {
+  tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+  fmt.Fprintf(tw, "FONT\tSTYLE\tACTION\tLOCAL\tREPO\n")
+  for _, fp := range p.Fonts {
+    if len(fp.Error) > 0 {
+      fmt.Fprintf(tw, "%s\t\terror\t\t\t(%s)\n", fp.Font, fp.Error)
+      continue
+    }
+    for _, it := range fp.Items {
+      style := it.Style
+      if len(style) == 0 {
+        style = "*"
+      }
+      action := it.Action.String()
+      if it.Unmanaged {
+        action += " (unmanaged)"
+      }
+      fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n",
+        fp.Font, style, action, versionOrDash(it.From), versionOrDash(it.To))
+    }
+  }
+  for _, o := range p.Orphans {
+    fmt.Fprintf(tw, "%s\t%s\tprune\t%s\t-\t(%s)\n",
+      o.Font, o.Family, versionOrDash(o.Version), o.Reason)
+  }
+  return tw.Flush()
+}
+
+
+// WriteJSON writes p as JSON to w
+//
+func (p *Plan) WriteJSON(w io.Writer) error {
+  enc := json.NewEncoder(w)
+  enc.SetIndent("", "  ")
+  return enc.Encode(p)
+}
+
+
+func versionOrDash(v *Version) string {
+  if v == nil {
+    return "-"
+  }
+  return v.String()
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+func TestPlanStyles(t *testing.T) {
+  local := func(style, version string) *FontFile {
+    f := &FontFile{ Style: style, Filename: style + ".otf" }
+    if err := f.Version.Parse(version); err != nil {
+      t.Fatal(err)
+    }
+    return f
+  }
+  ver, _ := ParseVersion("2.1.0")
+
+  locals := []*FontFile{
+    local("Regular", "2.1.0+abc123"),  // build metadata is ignored
+    local("Bold",    "2.0.0"),
+    local("Italic",  "3.0.0"),
+    local("Thin",    "2.1.0"),
+  }
+  styles := []string{ "regular", "Bold", "Italic", "Medium" }
+
+  expected := map[string]PlanAction{
+    "regular": PlanNoop,
+    "Bold":    PlanUpgrade,
+    "Italic":  PlanDowngrade,
+    "Medium":  PlanInstall,
+    "Thin":    PlanRemove,
+  }
+
+  items := planStyles(styles, ver, locals)
+  if len(items) != len(expected) {
+    t.Fatalf("got %d items; expected %d", len(items), len(expected))
+  }
+  for _, it := range items {
+    if it.Action != expected[it.Style] {
+      t.Errorf("%s => %s ; expected %s", it.Style, it.Action, expected[it.Style])
+    }
+  }
+
+  // no styles listed by the repo and nothing installed
+  items = planStyles(nil, ver, nil)
+  if len(items) != 1 || items[0].Action != PlanInstall {
+    t.Errorf("expected a single install item; got %+v", items)
+  }
+
+  // files which fontctrl didn't install are left alone and are not changes
+  items = planStyles(styles, ver, locals)
+  skipUnmanaged(items, &ReceiptDB{ Files: map[string]*Receipt{} })
+  fp := &FontPlan{}
+  for _, it := range items {
+    expect := expected[it.Style]
+    if expect != PlanNoop && expect != PlanInstall {
+      expect = PlanSkip
+    }
+    if it.Action != expect {
+      t.Errorf("unmanaged %s => %s ; expected %s", it.Style, it.Action, expect)
+    }
+    if it.Action != PlanInstall {
+      fp.Items = append(fp.Items, it)
+    }
+  }
+  if fp.HasChanges() {
+    t.Errorf("skipped items counted as changes")
+  }
+}
+
+
+func TestPlanStylesCollection(t *testing.T) {
+  face := func(style, version string) *FontFile {
+    f := &FontFile{ Style: style, Filename: "Family.ttc" }
+    if err := f.Version.Parse(version); err != nil {
+      t.Fatal(err)
+    }
+    return f
+  }
+  ver, _ := ParseVersion("2.1.0")
+
+  // the collection is replaced as a whole when any face is upgraded
+  items := planStyles([]string{ "Regular", "Bold" }, ver, []*FontFile{
+    face("Regular", "2.1.0"),
+    face("Bold",    "2.0.0"),
+  })
+  for _, it := range items {
+    if it.Action != PlanUpgrade {
+      t.Errorf("%s => %s ; expected %s", it.Style, it.Action, PlanUpgrade)
+    }
+  }
+
+  // a face is not removed while other faces of the file are kept
+  items = planStyles([]string{ "Regular" }, ver, []*FontFile{
+    face("Regular", "2.1.0"),
+    face("Bold",    "2.1.0"),
+  })
+  for _, it := range items {
+    if it.Action != PlanNoop {
+      t.Errorf("%s => %s ; expected %s", it.Style, it.Action, PlanNoop)
+    }
+  }
+}
+
+
+func TestComputePlanVersionInfoError(t *testing.T) {
+  tmpdir, err := ioutil.TempDir("", "fontctrl-test")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(tmpdir)
+
+  // the version info of "broken" is missing from the repo
+  repodir := filepath.Join(tmpdir, "repo")
+  writeTestFile(t, filepath.Join(repodir, "index.json"), `{"fonts":{
+    "broken":{"name":"Broken","versions":["1.0"]},
+    "inter":{"name":"Inter","versions":["3.19"]}}}`)
+  writeTestFile(t, filepath.Join(repodir, "inter", "inter-3.19.json"),
+    `{"version":"3.19","checksum":"abc","name":"Inter","styles":["Regular"]}`)
+  configFile := filepath.Join(tmpdir, "fontctrl.yml")
+  writeTestFile(t, configFile,
+    "repos:\n  - url: ./repo\nfonts:\n  broken: \"*\"\n  inter: \"*\"\n")
+  c := &Config{}
+  if err := c.LoadFile(configFile); err != nil {
+    t.Fatal(err)
+  }
+  if err := c.Repos[0].Update(); err != nil {
+    t.Fatal(err)
+  }
+
+  plan, err := computePlan(c, NewLocalFontIndex(nil), &ReceiptDB{}, nil)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(plan.Fonts) != 2 {
+    t.Fatalf("got %d fonts; expected 2", len(plan.Fonts))
+  }
+  if fp := plan.Fonts[0]; fp.Font != "broken" || len(fp.Error) == 0 {
+    t.Errorf("expected error for %s; got %+v", fp.Font, fp)
+  }
+  if fp := plan.Fonts[1]; fp.Font != "inter" || len(fp.Error) > 0 ||
+     len(fp.Items) != 1 || fp.Items[0].Action != PlanInstall {
+    t.Errorf("expected install of inter; got %+v", fp)
+  }
+}
+
+
+func TestPlanFromReceipts(t *testing.T) {
+  var versions []*Version
+  for _, s := range []string{ "r13", "r12", "r11" } {
+    v := &Version{}
+    if err := v.ParseIdentifier(s); err != nil {
+      t.Fatal(err)
+    }
+    versions = append(versions, v)
+  }
+  receipts := &ReceiptDB{ Files: map[string]*Receipt{
+    "Old.otf":     { Version: versions[2] },
+    "Current.otf": { Version: versions[1] },
+    "New.otf":     { Version: versions[0] },
+    "Gone.otf":    { Version: &Version{ Kind: VersionOpaque, Raw: "r1" } },
+  }}
+  tests := []struct {
+    filename string
+    action   PlanAction
+  }{
+    { "Old.otf", PlanUpgrade },
+    { "Current.otf", PlanNoop },
+    { "New.otf", PlanDowngrade },
+    { "Gone.otf", PlanNoop },
+    { "Unmanaged.otf", PlanNoop },
+  }
+  for _, test := range tests {
+    // the local version of the file is unrelated to the opaque repo version
+    lf := &FontFile{ Style: "Regular", Filename: test.filename }
+    lf.Version.Parse("1.0")
+    items := planStyles([]string{ "Regular" }, versions[1], []*FontFile{ lf })
+    planFromReceipts(items, versions, 1, receipts)
+    if items[0].Action != test.action {
+      t.Errorf("%s => %s ; expected %s", test.filename, items[0].Action, test.action)
+    }
+  }
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+  "bufio"
+  "fmt"
+  "os"
+  "sort"
+  "strings"
+)
+
+// Orphan is a file installed by fontctrl which no subscription accounts for
+//
+type Orphan struct {
+  Font     string   `json:"font"`  // font id from the file's receipt
+  Family   string   `json:"family"`
+  Version  *Version `json:"version,omitempty"`
+  Filename string   `json:"filename"`
+  Reason   string   `json:"reason"`
+}
+
+
+// findOrphans returns managed files in local that belong to fonts which are
+// no longer subscribed to in c, or whose family no longer matches the
+// family of the font in the repo or any of its aliases (e.g. because it was
+// renamed.) Repos should be updated for renames to be detected.
+//
+func findOrphans(c *Config, local *LocalFontIndex, receipts *ReceiptDB) []*Orphan {
+  // group faces by file, as a font collection is one file with many faces
+  var filenames []string
+  faces := make(map[string][]*FontFile)
+  for _, f := range local.Fonts() {
+    if receipts.Get(f.Filename) == nil {
+      continue  // not ours
+    }
+    if _, ok := faces[f.Filename]; !ok {
+      filenames = append(filenames, f.Filename)
+    }
+    faces[f.Filename] = append(faces[f.Filename], f)
+  }
+
+  var orphans []*Orphan
+  for _, filename := range filenames {
+    r := receipts.Get(filename)
+    fonts := faces[filename]
+    o := &Orphan{
+      Font:     r.Font,
+      Family:   fonts[0].Family,
+      Version:  r.Version,
+      Filename: filename,
+    }
+    if _, ok := c.Fonts[r.Font]; !ok {
+      o.Reason = "not subscribed"
+    } else if findex := c.FindFontIndex(r.Font); findex != nil &&
+              !hasFamily(fonts, subscribedFamilies(c, r.Font, findex)) {
+      o.Reason = fmt.Sprintf("family renamed to \"%s\"", findex.Family)
+    } else {
+      continue
+    }
+    orphans = append(orphans, o)
+  }
+  sort.Slice(orphans, func(i, j int) bool {
+    return orphans[i].Filename < orphans[j].Filename
+  })
+  return orphans
+}
+
+
+// subscribedFamilies returns the families of the font fid, including the
+// aliases of the version subscribed to
+//
+func subscribedFamilies(c *Config, fid string, findex *FontIndex) []string {
+  fsub := c.Fonts[fid]
+  var finfo *FontVersionInfo
+  if i, _ := fsub.VersionPattern.Match(findex.Versions); i != -1 {
+    var err error
+    if finfo, err = findex.GetVersionInfoAt(i); err != nil {
+      L.Printf("warning: %s: %v; ignoring aliases of repo", fid, err)
+    }
+  }
+  return c.FontFamilies(fid, findex, finfo)
+}
+
+
+// hasFamily returns true if any of fonts belong to any of families
+func hasFamily(fonts []*FontFile, families []string) bool {
+  for _, f := range fonts {
+    for _, family := range families {
+      if f.HasFamily(family) {
+        return true
+      }
+    }
+  }
+  return false
+}
+
+
+// pruneOrphans asks the user for confirmation (unless yes is true) and
+// then removes orphans from dir. Returns false if the user declined.
+//
+func pruneOrphans(
+  dir string,
+  receipts *ReceiptDB,
+  orphans []*Orphan,
+  yes bool,
+) (bool, error) {
+  if len(orphans) == 0 {
+    return true, nil
+  }
+
+  fmt.Printf("The following files were installed by fontctrl but are no longer needed:\n")
+  for _, o := range orphans {
+    fmt.Printf("  %s (%s %s; %s)\n", o.Filename, o.Font, versionOrDash(o.Version), o.Reason)
+  }
+  if !yes && !confirm(fmt.Sprintf("Remove %d files?", len(orphans))) {
+    return false, nil
+  }
+
+  filenames := make([]string, len(orphans))
+  for i, o := range orphans {
+    filenames[i] = o.Filename
+  }
+  removed, kept, err := removeManagedFiles(dir, receipts, filenames)
+  if err != nil {
+    return true, err
+  }
+  for _, filename := range removed {
+    fmt.Printf("removed %s\n", filename)
+  }
+  for _, filename := range kept {
+    fmt.Printf("left %s (modified since it was installed)\n", filename)
+  }
+  return true, nil
+}
+
+
+// confirm asks the user a yes/no question on stdin. Defaults to no.
+//
+func confirm(question string) bool {
+  fmt.Printf("%s [y/N] ", question)
+  line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+  switch strings.ToLower(strings.TrimSpace(line)) {
+    case "y", "yes": return true
+  }
+  return false
+}
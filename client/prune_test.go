@@ -0,0 +1,100 @@
+package main
+
+import (
+  "reflect"
+  "testing"
+  "gopkg.in/yaml.v2"
+)
+
+func TestFindOrphans(t *testing.T) {
+  dir := "/fonts"
+  receipts := &ReceiptDB{ fontDir: dir, Files: map[string]*Receipt{
+    "Inter-Regular.otf":   &Receipt{ Font: "inter" },
+    "InterUI-Regular.otf": &Receipt{ Font: "inter" },
+    "Roboto-Regular.ttf":  &Receipt{ Font: "roboto" },
+    "Noto.ttc":            &Receipt{ Font: "noto" },
+  }}
+  local := &LocalFontIndex{ fonts: []*FontFile{
+    &FontFile{ Filename: "/fonts/Inter-Regular.otf",   Family: "Inter" },
+    &FontFile{ Filename: "/fonts/InterUI-Regular.otf", Family: "Inter UI" },
+    &FontFile{ Filename: "/fonts/Roboto-Regular.ttf",  Family: "Roboto" },
+    &FontFile{ Filename: "/fonts/Mine-Regular.otf",    Family: "Mine" },
+    &FontFile{ Filename: "/fonts/Noto.ttc", Family: "Noto Sans", Face: 0 },
+    &FontFile{ Filename: "/fonts/Noto.ttc", Family: "Noto Serif", Face: 1 },
+  }}
+  repo := &Repo{ Index: RepoIndex{ Fonts: map[string]*FontIndex{
+    "inter": &FontIndex{ Id: "inter", Family: "Inter" },
+  }}}
+  c := &Config{
+    Repos: []*Repo{ repo },
+    Fonts: map[string]FontSubscription{ "inter": FontSubscription{} },
+  }
+
+  orphans := findOrphans(c, local, receipts)
+  expected := []string{
+    "/fonts/InterUI-Regular.otf",
+    "/fonts/Noto.ttc",  // once, although the collection has two faces
+    "/fonts/Roboto-Regular.ttf",
+  }
+  if len(orphans) != len(expected) {
+    t.Fatalf("got %d orphans; expected %d", len(orphans), len(expected))
+  }
+  for i, o := range orphans {
+    if o.Filename != expected[i] {
+      t.Errorf("orphans[%d] = %s ; expected %s", i, o.Filename, expected[i])
+    }
+  }
+}
+
+
+func TestFindOrphansAliases(t *testing.T) {
+  dir := "/fonts"
+  receipts := &ReceiptDB{ fontDir: dir, Files: map[string]*Receipt{
+    "InterUI-Regular.otf": &Receipt{ Font: "inter" },
+    "Roboto2-Regular.ttf": &Receipt{ Font: "roboto" },
+  }}
+  local := NewLocalFontIndex(nil)
+  local.addFont(&FontFile{ Filename: "/fonts/InterUI-Regular.otf", Family: "Inter UI" })
+  local.addFont(&FontFile{ Filename: "/fonts/Roboto2-Regular.ttf", Family: "Roboto 2" })
+  v1 := &Version{}
+  if err := v1.Parse("3.0.0"); err != nil {
+    t.Fatal(err)
+  }
+  repo := &Repo{ Index: RepoIndex{ Fonts: map[string]*FontIndex{
+    // alias in the repo's version info
+    "inter": &FontIndex{ Id: "inter", Family: "Inter", Versions: []*Version{ v1 },
+      vinfo: []*FontVersionInfo{ &FontVersionInfo{ Aliases: []string{ "Inter UI" } } } },
+    "roboto": &FontIndex{ Id: "roboto", Family: "Roboto" },
+  }}}
+
+  // alias in the subscription
+  var fonts map[string]FontSubscription
+  err := yaml.Unmarshal([]byte(`
+inter: "*"
+roboto:
+  version: ">=2"
+  aliases: [ "Roboto 2" ]
+`), &fonts)
+  if err != nil {
+    t.Fatal(err)
+  }
+  c := &Config{ Repos: []*Repo{ repo }, Fonts: fonts }
+  if a := c.Fonts["roboto"].Aliases; !reflect.DeepEqual(a, []string{ "Roboto 2" }) {
+    t.Errorf("roboto aliases = %q ; expected [\"Roboto 2\"]", a)
+  }
+
+  if orphans := findOrphans(c, local, receipts); len(orphans) != 0 {
+    t.Errorf("got %d orphans; expected aliases to match installed families",
+      len(orphans))
+  }
+
+  families := c.FontFamilies("inter", repo.Index.Fonts["inter"],
+    repo.Index.Fonts["inter"].vinfo[0])
+  if !reflect.DeepEqual(families, []string{ "Inter", "Inter UI" }) {
+    t.Errorf("FontFamilies(\"inter\") = %q ; expected [\"Inter\" \"Inter UI\"]",
+      families)
+  }
+  if fonts := local.FindFamilies(families); len(fonts) != 1 {
+    t.Errorf("FindFamilies(%q) found %d fonts; expected 1", families, len(fonts))
+  }
+}
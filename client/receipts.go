@@ -0,0 +1,154 @@
+package main
+
+import (
+  "crypto/sha1"
+  "encoding/hex"
+  "encoding/json"
+  "io"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "sort"
+  "strings"
+  "time"
+)
+
+// Receipt records how a file in the font dir was installed by fontctrl.
+// Files without a receipt were put there by someone else and must never
+// be modified or removed.
+//
+type Receipt struct {
+  Font      string    `json:"font"`      // font id
+  Version   *Version  `json:"version"`
+  Repo      string    `json:"repo"`      // repo url
+  Checksum  string    `json:"checksum"`  // SHA-1 of the archive
+  Hash      string    `json:"hash"`      // SHA-1 of the installed file
+  Installed time.Time `json:"installed"`
+}
+
+// ReceiptDB is the set of receipts for a font dir, stored in
+// <fontdir>/.fontctrl/receipts.json
+//
+type ReceiptDB struct {
+  Files map[string]*Receipt `json:"files"`  // keyed by slash-separated path
+                                           // relative to the font dir
+  fontDir string
+}
+
+
+func receiptsFile(fontDir string) string {
+  return filepath.Join(stateDir(fontDir), "receipts.json")
+}
+
+
+// LoadReceipts reads the receipt database of fontDir.
+// A missing database is not an error and yields an empty database.
+//
+func LoadReceipts(fontDir string) (*ReceiptDB, error) {
+  db := &ReceiptDB{ fontDir: fontDir }
+  data, err := ioutil.ReadFile(receiptsFile(fontDir))
+  if err == nil {
+    err = json.Unmarshal(data, db)
+  } else if os.IsNotExist(err) {
+    err = nil
+  }
+  if db.Files == nil {
+    db.Files = make(map[string]*Receipt)
+  }
+  return db, err
+}
+
+
+// Save writes db to disk
+//
+func (db *ReceiptDB) Save() error {
+  data, err := json.MarshalIndent(db, "", "  ")
+  if err != nil {
+    return err
+  }
+  return writeFileAtomic(receiptsFile(db.fontDir), data, 0644)
+}
+
+
+// Get returns the receipt for filename, which can be either absolute or
+// relative to the font dir. Returns nil if there's no receipt.
+//
+func (db *ReceiptDB) Get(filename string) *Receipt {
+  return db.Files[db.key(filename)]
+}
+
+// Set records r as the receipt for filename
+//
+func (db *ReceiptDB) Set(filename string, r *Receipt) {
+  db.Files[db.key(filename)] = r
+}
+
+// Delete removes the receipt for filename
+//
+func (db *ReceiptDB) Delete(filename string) {
+  delete(db.Files, db.key(filename))
+}
+
+
+// IsManaged returns true if filename was installed by fontctrl and has not
+// been modified since.
+//
+func (db *ReceiptDB) IsManaged(filename string) bool {
+  r := db.Get(filename)
+  if r == nil {
+    return false
+  }
+  hash, err := hashFile(db.Path(filename))
+  return err == nil && strings.EqualFold(hash, r.Hash)
+}
+
+
+// FontFiles returns the absolute paths of the files installed for the font
+// identified by fid, in lexical order
+//
+func (db *ReceiptDB) FontFiles(fid string) []string {
+  var files []string
+  for name, r := range db.Files {
+    if r.Font == fid {
+      files = append(files, db.Path(name))
+    }
+  }
+  sort.Strings(files)
+  return files
+}
+
+
+// Path returns the absolute path of filename in the font dir
+//
+func (db *ReceiptDB) Path(filename string) string {
+  if filepath.IsAbs(filename) {
+    return filename
+  }
+  return filepath.Join(db.fontDir, filepath.FromSlash(filename))
+}
+
+
+func (db *ReceiptDB) key(filename string) string {
+  if filepath.IsAbs(filename) {
+    if rel, err := filepath.Rel(db.fontDir, filename); err == nil {
+      filename = rel
+    }
+  }
+  return filepath.ToSlash(filepath.Clean(filename))
+}
+
+
+// hashFile returns the hexadecimal SHA-1 checksum of a file's contents
+//
+func hashFile(filename string) (string, error) {
+  fp, err := os.Open(filename)
+  if err != nil {
+    return "", err
+  }
+  defer fp.Close()
+  h := sha1.New()
+  if _, err := io.Copy(h, fp); err != nil {
+    return "", err
+  }
+  return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+func TestReceiptDB(t *testing.T) {
+  tmpdir, err := ioutil.TempDir("", "fontctrl-test")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(tmpdir)
+
+  // a relative font-dir is made absolute, relative to the config file
+  configFile := filepath.Join(tmpdir, "fontctrl.yml")
+  writeTestFile(t, configFile, "font-dir: fonts\nfonts: {}\n")
+  c := &Config{}
+  if err := c.LoadFile(configFile); err != nil {
+    t.Fatal(err)
+  }
+  fontDir := filepath.Join(tmpdir, "fonts")
+  if c.FontDir != fontDir {
+    t.Fatalf("FontDir = %s ; expected %s", c.FontDir, fontDir)
+  }
+
+  filename := filepath.Join(fontDir, "Inter", "Inter-Regular.otf")
+  writeTestFile(t, filename, "regular")
+  hash, err := hashFile(filename)
+  if err != nil {
+    t.Fatal(err)
+  }
+  db, err := LoadReceipts(c.FontDir)
+  if err != nil {
+    t.Fatal(err)
+  }
+  db.Set(filename, &Receipt{ Font: "inter", Hash: hash })
+  if err := db.Save(); err != nil {
+    t.Fatal(err)
+  }
+
+  // receipts are found by absolute path or path relative to the font dir
+  db, err = LoadReceipts(c.FontDir)
+  if err != nil {
+    t.Fatal(err)
+  }
+  for _, name := range []string{ filename, filepath.Join("Inter", "Inter-Regular.otf") } {
+    if !db.IsManaged(name) {
+      t.Errorf("%s is not managed", name)
+    }
+  }
+  if files := db.FontFiles("inter"); len(files) != 1 || files[0] != filename {
+    t.Errorf("FontFiles(\"inter\") = %q ; expected [%q]", files, filename)
+  }
+  if db.IsManaged(filepath.Join(fontDir, "Other.otf")) {
+    t.Errorf("file without receipt is managed")
+  }
+
+  // files modified since they were installed are not managed
+  writeTestFile(t, filename, "modified")
+  if db.IsManaged(filename) {
+    t.Errorf("modified file is managed")
+  }
+  if db.Get(filename) == nil {
+    t.Errorf("receipt of modified file is gone")
+  }
+
+  db.Delete(filename)
+  if db.Get(filename) != nil || len(db.FontFiles("inter")) != 0 {
+    t.Errorf("receipt not removed")
+  }
+}
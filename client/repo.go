@@ -1,19 +1,34 @@
 package main
 
 import (
-  "encoding/json"
-  Path "path"
-  "net/http"
+  "crypto/tls"
+  "crypto/x509"
   "fmt"
-  "time"
+  "io/ioutil"
+  "net/http"
+  "net/url"
+  "path/filepath"
   "strings"
+  "time"
 )
 
-var httpClient = &http.Client{Timeout: 30 * time.Second}
+const defaultRepoTimeout = 30 * time.Second
 
 type Repo struct {
-  Url   string `json:"url"`
+  Url   string    `json:"url"`
+  Auth  *RepoAuth `json:"-" yaml:"auth,omitempty"`
   Index RepoIndex
+
+  // settings for repos served over HTTP(S). Files are relative to dir.
+  CAFile     string `json:"-" yaml:"ca-file,omitempty"`     // PEM certificates
+  ClientCert string `json:"-" yaml:"client-cert,omitempty"` // PEM, for mTLS
+  ClientKey  string `json:"-" yaml:"client-key,omitempty"`  // PEM, for mTLS
+  Proxy      string `json:"-" yaml:"proxy,omitempty"`       // default $HTTPS_PROXY
+  Timeout    string `json:"-" yaml:"timeout,omitempty"`     // e.g. "1m"
+  Insecure   bool   `json:"-" yaml:"insecure-skip-verify,omitempty"`
+
+  source RepoSource  // created from Url on first use
+  dir    string      // relative paths in Url are relative to dir
 }
 
 // RepoIndex corresponds to repo/index.json
@@ -47,20 +62,7 @@ type FontVersionInfo struct {
   InfoUrl     string   `json:"info_url"`
   Authors     []string `json:"authors"`
   License     string   `json:"license"`
-}
-
-
-func fetchJson(url string, v interface{}) error {
-  res, err := httpClient.Get(url)
-  if err != nil {
-    return err
-  }
-  defer res.Body.Close()
-  if res.StatusCode < 200 || res.StatusCode > 299 {
-    return fmt.Errorf("%d %s (GET %s)",
-      res.StatusCode, http.StatusText(res.StatusCode), url)
-  }
-  return json.NewDecoder(res.Body).Decode(v)
+  Aliases     []string `json:"aliases"`  // former or alternate family names
 }
 
 
@@ -79,16 +81,12 @@ func (f *FontIndex) GetVersionInfoAt(i int) (*FontVersionInfo, error) {
     return fvi, nil
   }
 
-  ver := f.Versions[i]
-  url, err := f.Repo.GetUrl(fmt.Sprintf("%s/%s-%s.json", f.Id, f.Id, ver))
+  src, err := f.Repo.Source()
   if err != nil {
     return nil, err
   }
-
-  L.Printf("fetching %s", url)
-
   fvi = &FontVersionInfo{}
-  if err := fetchJson(url, fvi); err != nil {
+  if err := src.FetchVersionInfo(f.Id, f.Versions[i], fvi); err != nil {
     return nil, err
   }
   f.vinfo[i] = fvi
@@ -97,94 +95,143 @@ func (f *FontIndex) GetVersionInfoAt(i int) (*FontVersionInfo, error) {
 }
 
 
+// GetArchiveUrlAt returns the URL of the archive for the corresponding
+// version in f.Versions. fvi.ArchiveUrl is used when set, otherwise the
+// archive is expected at <font>/<font>-<version>.zip in the repo.
+//
+func (f *FontIndex) GetArchiveUrlAt(i int, fvi *FontVersionInfo) (string, error) {
+  if fvi != nil && len(fvi.ArchiveUrl) > 0 {
+    return fvi.ArchiveUrl, nil
+  }
+  src, err := f.Repo.Source()
+  if err != nil {
+    return "", err
+  }
+  return src.ArchiveURL(f.Id, f.Versions[i]), nil
+}
+
+
 func (r *Repo) String() string {
   if r == nil {
     return "<nil Repo>"
   }
-  return r.Url
+  return redactURL(r.Url)
 }
 
 
-func withTrailingSlash(s string) string {
-  if s[len(s)-1] != '/' {
-    return s + "/"
+// Source returns the source of r, creating it from r.Url on first use
+//
+func (r *Repo) Source() (RepoSource, error) {
+  if r.source == nil {
+    url := r.Url
+    if len(repoURLScheme(url)) == 0 {
+      url = r.path(url)
+    }
+    src, err := OpenRepoSource(r, url)
+    if err != nil {
+      return nil, err
+    }
+    r.source = src
   }
-  return s
+  return r.source, nil
 }
 
-func parseGithubRepoUrl(s string, path string) (string, error) {
-  // expect "user/repo/path?#branch?"
-  br := "master"
-  var ps string
-  i, p := 0, 0
-  for i < len(s) {
-    if s[i] == '/' {
-      if p == 1 {
-        ps = s[i:]
-        s = s[:i]
-        if x := strings.IndexByte(ps, '#'); x > -1 {
-          br = ps[x+1:]
-          ps = ps[:x]
-        }
-        break
-      }
-      p++
-    }
-    i++
-  }
 
-  if p == 0 {
-    // no slash found
-    return s, fmt.Errorf(
-      "invalid github repo url \"%s\"; expected user/repo", s)
+// path returns filename relative to the directory of r's config file,
+// with a leading "~/" replaced by the user's home directory
+//
+func (r *Repo) path(filename string) string {
+  if strings.HasPrefix(filename, "~/") || strings.HasPrefix(filename, "~\\") {
+    return filepath.Join(homeDir, filename[2:])
   }
-
-  if len(ps) == 0 {
-    if p = strings.IndexByte(s, '#'); p > -1 {
-      br = s[p+1:]
-      s = s[:p]
-    }
+  if !filepath.IsAbs(filename) && len(r.dir) > 0 {
+    return filepath.Join(r.dir, filename)
   }
-
-  s = "https://raw.githubusercontent.com/" + Path.Join(s, br, ps, path)
-
-  return s, nil
+  return filename
 }
 
 
-func (r *Repo) GetUrl(path string) (string, error) {
-  s := r.Url
-  p := strings.IndexByte(s, ':')
-  if p == -1 {
-    return s, fmt.Errorf("invalid repo url \"%s\"; missing prototcol", s)
+// httpClient creates a client for requests to r, with a transport
+// configured by the TLS, proxy and timeout settings of r.
+// r may be nil, for a client with default settings.
+//
+func (r *Repo) httpClient() (*http.Client, error) {
+  client := &http.Client{ Timeout: defaultRepoTimeout }
+  if r == nil {
+    return client, nil
+  }
+  if len(r.Timeout) > 0 {
+    d, err := time.ParseDuration(r.Timeout)
+    if err != nil {
+      return nil, fmt.Errorf("invalid timeout \"%s\" for repo %s", r.Timeout, r)
+    }
+    client.Timeout = d  // 0 = no timeout
+  }
+
+  tlsConfig := &tls.Config{ InsecureSkipVerify: r.Insecure }
+  if r.Insecure {
+    L.Printf("warning: not verifying TLS certificates of repo %s", r)
+  }
+  if len(r.CAFile) > 0 {
+    filename := r.path(r.CAFile)
+    data, err := ioutil.ReadFile(filename)
+    if err != nil {
+      return nil, err
+    }
+    // the CA file adds to, rather than replaces, the system's CAs
+    pool, err := x509.SystemCertPool()
+    if err != nil {
+      pool = x509.NewCertPool()
+    }
+    if !pool.AppendCertsFromPEM(data) {
+      return nil, fmt.Errorf("no PEM certificates found in %s", filename)
+    }
+    tlsConfig.RootCAs = pool
+  }
+  if len(r.ClientCert) > 0 || len(r.ClientKey) > 0 {
+    if len(r.ClientCert) == 0 || len(r.ClientKey) == 0 {
+      return nil, fmt.Errorf(
+        "repo %s: client-cert and client-key must be set together", r)
+    }
+    cert, err := tls.LoadX509KeyPair(r.path(r.ClientCert), r.path(r.ClientKey))
+    if err != nil {
+      return nil, fmt.Errorf("repo %s: client certificate: %v", r, err)
+    }
+    tlsConfig.Certificates = []tls.Certificate{ cert }
   }
-  proto := s[:p]
-  switch proto {
-    case "http": // pass
-    case "https": // pass
-    case "github": return parseGithubRepoUrl(s[p+1:], path)
-    default:
-      return s, fmt.Errorf(
-        "can not understand repo url \"%s\"; unknown protocol", s)
+
+  transport := http.DefaultTransport.(*http.Transport).Clone()
+  transport.TLSClientConfig = tlsConfig
+  if len(r.Proxy) > 0 {
+    u, err := url.Parse(r.Proxy)
+    if err != nil || len(u.Host) == 0 {
+      return nil, fmt.Errorf(
+        "invalid proxy \"%s\" for repo %s", redactURL(r.Proxy), r)
+    }
+    transport.Proxy = http.ProxyURL(u)
   }
-  return withTrailingSlash(s) + path, nil
+  client.Transport = transport
+  return client, nil
 }
 
 
 func (r *Repo) Update() error {
-  url, err := r.GetUrl("index.json")
+  src, err := r.Source()
   if err != nil {
     return err
   }
-
-  L.Printf("url: %s\n", url)
-  if err := fetchJson(url, &r.Index); err != nil {
+  if err := src.FetchIndex(&r.Index); err != nil {
     return err
   }
 
   for id, f := range r.Index.Fonts {
     f.Id = id
     f.Repo = r
+    // versions are listed from most recent to least recent, which is the
+    // only way to order versions that are not SemVer or CalVer
+    for i, v := range f.Versions {
+      v.Order = len(f.Versions) - i
+    }
     SortVersions(f.Versions)
   }
 
@@ -0,0 +1,126 @@
+package main
+
+import (
+  "crypto/ecdsa"
+  "crypto/elliptic"
+  "crypto/rand"
+  "crypto/tls"
+  "crypto/x509"
+  "crypto/x509/pkix"
+  "encoding/pem"
+  "io"
+  "io/ioutil"
+  "log"
+  "math/big"
+  "net/http"
+  "net/http/httptest"
+  "os"
+  "path/filepath"
+  "testing"
+  "time"
+)
+
+const testRepoIndex = `{"fonts":{"inter":{"name":"Inter","versions":["3.19"]}}}`
+
+func TestRepoHTTPClient(t *testing.T) {
+  tmpdir, err := ioutil.TempDir("", "fontctrl-test")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(tmpdir)
+
+  // client certificate
+  key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+  if err != nil {
+    t.Fatal(err)
+  }
+  tmpl := &x509.Certificate{
+    SerialNumber: big.NewInt(1),
+    Subject:      pkix.Name{ CommonName: "fontctrl-test" },
+    NotBefore:    time.Now().Add(-time.Hour),
+    NotAfter:     time.Now().Add(time.Hour),
+    KeyUsage:     x509.KeyUsageDigitalSignature,
+    ExtKeyUsage:  []x509.ExtKeyUsage{ x509.ExtKeyUsageClientAuth },
+  }
+  der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+  if err != nil {
+    t.Fatal(err)
+  }
+  keyder, err := x509.MarshalECPrivateKey(key)
+  if err != nil {
+    t.Fatal(err)
+  }
+  writeTestFile(t, filepath.Join(tmpdir, "client.pem"), string(
+    pem.EncodeToMemory(&pem.Block{ Type: "CERTIFICATE", Bytes: der })))
+  writeTestFile(t, filepath.Join(tmpdir, "client-key.pem"), string(
+    pem.EncodeToMemory(&pem.Block{ Type: "EC PRIVATE KEY", Bytes: keyder })))
+  clientCert, _ := x509.ParseCertificate(der)
+
+  // TLS server that requires the client certificate
+  serveIndex := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    io.WriteString(w, testRepoIndex)
+  })
+  srv := httptest.NewUnstartedServer(serveIndex)
+  srv.TLS = &tls.Config{
+    ClientAuth: tls.RequireAndVerifyClientCert,
+    ClientCAs:  x509.NewCertPool(),
+  }
+  srv.TLS.ClientCAs.AddCert(clientCert)
+  srv.Config.ErrorLog = log.New(ioutil.Discard, "", 0)  // handshake errors
+  srv.StartTLS()
+  defer srv.Close()
+  writeTestFile(t, filepath.Join(tmpdir, "ca.pem"), string(pem.EncodeToMemory(
+    &pem.Block{ Type: "CERTIFICATE", Bytes: srv.Certificate().Raw })))
+
+  // proxy for plain HTTP, which serves all requests itself
+  var proxied string
+  proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    proxied = r.URL.String()
+    io.WriteString(w, testRepoIndex)
+  }))
+  defer proxy.Close()
+
+  slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    time.Sleep(500 * time.Millisecond)
+    io.WriteString(w, testRepoIndex)
+  }))
+  defer slow.Close()
+
+  tests := []struct {
+    name string
+    repo *Repo
+    ok   bool
+  }{
+    { "unknown CA", &Repo{ Url: srv.URL }, false },
+    { "no client cert", &Repo{ Url: srv.URL, CAFile: "ca.pem" }, false },
+    { "mTLS", &Repo{
+        Url:        srv.URL,
+        CAFile:     "ca.pem",
+        ClientCert: "client.pem",
+        ClientKey:  "client-key.pem",
+      }, true },
+    { "insecure", &Repo{
+        Url:        srv.URL,
+        ClientCert: "client.pem",
+        ClientKey:  "client-key.pem",
+        Insecure:   true,
+      }, true },
+    { "missing client key", &Repo{ Url: srv.URL, ClientCert: "client.pem" }, false },
+    { "invalid CA file", &Repo{ Url: srv.URL, CAFile: "client-key.pem" }, false },
+    { "proxy", &Repo{ Url: "http://fonts.example.com/", Proxy: proxy.URL }, true },
+    { "timeout", &Repo{ Url: slow.URL, Timeout: "50ms" }, false },
+    { "invalid timeout", &Repo{ Url: slow.URL, Timeout: "soon" }, false },
+  }
+  for _, test := range tests {
+    test.repo.dir = tmpdir
+    err := test.repo.Update()
+    if test.ok && err != nil {
+      t.Errorf("%s: %v", test.name, err)
+    } else if !test.ok && err == nil {
+      t.Errorf("%s: expected error", test.name)
+    }
+  }
+  if proxied != "http://fonts.example.com/index.json" {
+    t.Errorf("proxy got request for %q ; expected index.json of repo", proxied)
+  }
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+  "bufio"
+  "bytes"
+  "fmt"
+  "io"
+  "net/http"
+  "net/url"
+  "os"
+  "os/exec"
+  "path/filepath"
+  "strings"
+  "sync"
+)
+
+// RepoAuth configures how fontctrl authenticates with a repo, corresponding
+// to "auth" of a repo in the config file. At most one of Token,
+// Username+Password, Helper and Netrc should be set. Token and Password may
+// be the name of an environment variable, e.g. "$FONTS_TOKEN" or
+// "${FONTS_TOKEN}", to keep secrets out of the config file. Other values
+// are used literally, even if they contain "$".
+//
+// Token and Username+Password are only sent to the host of the repo URL.
+// Helper and Netrc look up credentials for whatever host is requested,
+// e.g. when an archive is stored elsewhere. If several are set, the first
+// in the order Token, Username, Helper, Netrc is used.
+//
+type RepoAuth struct {
+  Username string `yaml:"username,omitempty"`
+  Password string `yaml:"password,omitempty"`
+  Token    string `yaml:"token,omitempty"`  // bearer token
+  Helper   string `yaml:"helper,omitempty"` // credential helper command
+  Netrc    string `yaml:"netrc,omitempty"`  // "true" for ~/.netrc, or a file
+
+  credmu sync.Mutex
+  creds  map[string]*repoCredentials  // by host; from Helper or Netrc
+}
+
+type repoCredentials struct {
+  Username string
+  Password string
+}
+
+
+// authorize adds credentials to req, which is a request to a file of a repo
+// at host repoHost
+//
+func (a *RepoAuth) authorize(req *http.Request, repoHost string) error {
+  if a == nil {
+    return nil
+  }
+  host := req.URL.Host
+  switch {
+  case len(a.Token) > 0:
+    if host == repoHost {
+      token, err := expandSecret(a.Token)
+      if err != nil {
+        return fmt.Errorf("token: %v", err)
+      }
+      req.Header.Set("Authorization", "Bearer " + token)
+    }
+  case len(a.Username) > 0:
+    if host == repoHost {
+      password, err := expandSecret(a.Password)
+      if err != nil {
+        return fmt.Errorf("password: %v", err)
+      }
+      req.SetBasicAuth(a.Username, password)
+    }
+  case len(a.Helper) > 0 || len(a.netrcFile()) > 0:
+    creds, err := a.lookupCredentials(req.URL)
+    if err != nil {
+      return err
+    }
+    if creds != nil {
+      req.SetBasicAuth(creds.Username, creds.Password)
+    }
+  }
+  return nil
+}
+
+
+// expandSecret returns the value of the environment variable named by s if
+// s is "$NAME" or "${NAME}", or else s as-is. Returns an error if the
+// variable is not set.
+//
+func expandSecret(s string) (string, error) {
+  name := ""
+  if strings.HasPrefix(s, "${") && strings.HasSuffix(s, "}") {
+    name = s[2:len(s) - 1]
+  } else if strings.HasPrefix(s, "$") {
+    name = s[1:]
+  }
+  if len(name) == 0 || !isEnvName(name) {
+    return s, nil
+  }
+  value, ok := os.LookupEnv(name)
+  if !ok {
+    return "", fmt.Errorf("environment variable %s is not set", name)
+  }
+  return value, nil
+}
+
+func isEnvName(s string) bool {
+  for i, c := range s {
+    if !(c == '_' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' ||
+         i > 0 && c >= '0' && c <= '9') {
+      return false
+    }
+  }
+  return true
+}
+
+
+// lookupCredentials returns credentials for the host of u from a.Helper or
+// a.Netrc, or nil if there are none
+//
+func (a *RepoAuth) lookupCredentials(u *url.URL) (*repoCredentials, error) {
+  a.credmu.Lock()
+  defer a.credmu.Unlock()
+  if creds, ok := a.creds[u.Host]; ok {
+    return creds, nil
+  }
+
+  var creds *repoCredentials
+  var err error
+  if len(a.Helper) > 0 {
+    creds, err = runCredentialHelper(a.Helper, u)
+  } else {
+    creds, err = readNetrc(a.netrcFile(), u.Hostname())
+  }
+  if err != nil {
+    return nil, err
+  }
+  if a.creds == nil {
+    a.creds = make(map[string]*repoCredentials)
+  }
+  a.creds[u.Host] = creds
+  return creds, nil
+}
+
+
+// runCredentialHelper asks the credential helper command for credentials
+// for u, using the protocol of git credential helpers: the command is run
+// with the argument "get", is given "key=value" lines describing u on stdin
+// and is expected to reply with "username=..." and "password=..." lines.
+// See https://git-scm.com/docs/git-credential
+//
+func runCredentialHelper(command string, u *url.URL) (*repoCredentials, error) {
+  args := strings.Fields(command)
+  if len(args) == 0 {
+    return nil, fmt.Errorf("empty credential helper command")
+  }
+  cmd := exec.Command(args[0], append(args[1:], "get")...)
+  cmd.Stdin = strings.NewReader(fmt.Sprintf("protocol=%s\nhost=%s\npath=%s\n\n",
+    u.Scheme, u.Host, strings.TrimPrefix(u.Path, "/")))
+  cmd.Stderr = os.Stderr
+  out, err := cmd.Output()
+  if err != nil {
+    // note: the output may contain secrets and is not included
+    return nil, fmt.Errorf("credential helper \"%s\" failed: %v", args[0], err)
+  }
+
+  creds := &repoCredentials{}
+  s := bufio.NewScanner(bytes.NewReader(out))
+  for s.Scan() {
+    kv := strings.SplitN(s.Text(), "=", 2)
+    if len(kv) != 2 {
+      continue
+    }
+    switch kv[0] {
+      case "username": creds.Username = kv[1]
+      case "password": creds.Password = kv[1]
+    }
+  }
+  if len(creds.Username) == 0 && len(creds.Password) == 0 {
+    return nil, nil  // helper has no credentials for u
+  }
+  return creds, nil
+}
+
+
+// netrcFile returns the name of the netrc file to read, or "" if a.Netrc is
+// unset or "false". "true" means $NETRC or else the user's ~/.netrc.
+//
+func (a *RepoAuth) netrcFile() string {
+  switch a.Netrc {
+  case "", "false":
+    return ""
+  case "true":
+    if s := os.Getenv("NETRC"); len(s) > 0 {
+      return s
+    }
+    name := ".netrc"
+    if os.PathSeparator == '\\' {
+      name = "_netrc"
+    }
+    return filepath.Join(homeDir, name)
+  }
+  if strings.HasPrefix(a.Netrc, "~/") || strings.HasPrefix(a.Netrc, "~\\") {
+    return filepath.Join(homeDir, a.Netrc[2:])
+  }
+  return a.Netrc
+}
+
+
+// readNetrc returns the credentials for host in the netrc file filename,
+// falling back to its "default" entry. Returns nil if there are none.
+//
+func readNetrc(filename, host string) (*repoCredentials, error) {
+  fp, err := os.Open(filename)
+  if err != nil {
+    if os.IsNotExist(err) {
+      return nil, nil
+    }
+    return nil, err
+  }
+  defer fp.Close()
+  return parseNetrc(fp, host)
+}
+
+func parseNetrc(r io.Reader, host string) (*repoCredentials, error) {
+  var match, deflt, creds *repoCredentials
+  s := bufio.NewScanner(r)
+  s.Split(bufio.ScanWords)
+  for s.Scan() {
+    switch s.Text() {
+    case "machine":
+      creds = nil
+      if s.Scan() && s.Text() == host && match == nil {
+        match = &repoCredentials{}
+        creds = match
+      }
+    case "default":
+      creds = nil
+      if deflt == nil {
+        deflt = &repoCredentials{}
+        creds = deflt
+      }
+    case "login":
+      if s.Scan() && creds != nil {
+        creds.Username = s.Text()
+      }
+    case "password":
+      if s.Scan() && creds != nil {
+        creds.Password = s.Text()
+      }
+    case "macdef":
+      // macro definitions run until an empty line, which ScanWords can't
+      // see; netrc files used for credentials rarely contain them
+      creds = nil
+    }
+  }
+  if err := s.Err(); err != nil {
+    return nil, err
+  }
+  if match != nil {
+    return match, nil
+  }
+  return deflt, nil
+}
+
+
+// redactURL returns s with any password replaced, for logging
+func redactURL(s string) string {
+  u, err := url.Parse(s)
+  if err != nil || u.User == nil {
+    return s
+  }
+  if _, ok := u.User.Password(); ok {
+    u.User = url.UserPassword(u.User.Username(), "xxxxx")
+  }
+  return u.String()
+}
+
+
+// stripURLUserinfo returns s without any username and password, for URLs
+// written to files that are shared, like the lock file
+//
+func stripURLUserinfo(s string) string {
+  u, err := url.Parse(s)
+  if err != nil || u.User == nil {
+    return s
+  }
+  u.User = nil
+  return u.String()
+}
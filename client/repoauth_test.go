@@ -0,0 +1,214 @@
+package main
+
+import (
+  "bytes"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "net/http"
+  "net/http/httptest"
+  "os"
+  "path/filepath"
+  "runtime"
+  "strings"
+  "testing"
+
+  "gopkg.in/yaml.v2"
+)
+
+func TestRepoAuth(t *testing.T) {
+  tmpdir, err := ioutil.TempDir("", "fontctrl-test")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(tmpdir)
+
+  // the server accepts either the bearer token or basic auth
+  const token = "s3cr3t-t0k3n"
+  const password = "s3cr3t-passw0rd"
+  srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    user, pass, ok := r.BasicAuth()
+    if r.Header.Get("Authorization") != "Bearer " + token &&
+       !(ok && user == "fonts" && pass == password) {
+      http.Error(w, "unauthorized", http.StatusUnauthorized)
+      return
+    }
+    io.WriteString(w, `{"fonts":{"inter":{"name":"Inter","versions":["3.19"]}}}`)
+  }))
+  defer srv.Close()
+  host := strings.TrimPrefix(srv.URL, "http://")
+
+  netrc := filepath.Join(tmpdir, "netrc")
+  writeTestFile(t, netrc, fmt.Sprintf(
+    "machine example.com login other password other\n" +
+    "machine %s\n  login fonts\n  password %s\n" +
+    "default login nobody password nobody\n",
+    strings.Split(host, ":")[0], password))
+
+  helper := filepath.Join(tmpdir, "credential-helper")
+  writeTestFile(t, helper, fmt.Sprintf(
+    "#!/bin/sh\n" +
+    "test \"$1\" = get || exit 1\n" +
+    "grep -q '^host=%s$' || exit 0\n" +
+    "echo username=fonts\n" +
+    "echo password=%s\n", host, password))
+  os.Chmod(helper, 0755)
+
+  os.Setenv("FONTCTRL_TEST_TOKEN", token)
+  defer os.Unsetenv("FONTCTRL_TEST_TOKEN")
+
+  tests := []struct {
+    name string
+    auth string  // yaml
+    ok   bool
+  }{
+    { "none", "", false },
+    { "token", "token: " + token, true },
+    { "token from env", "token: $FONTCTRL_TEST_TOKEN", true },
+    { "token from env with braces", "token: ${FONTCTRL_TEST_TOKEN}", true },
+    { "wrong token", "token: nope", false },
+    { "basic", "username: fonts\npassword: " + password, true },
+    { "wrong password", "username: fonts\npassword: nope", false },
+    { "netrc", "netrc: " + netrc, true },
+    { "netrc disabled", "netrc: false", false },
+    { "helper", "helper: " + helper, true },
+  }
+
+  var logbuf bytes.Buffer
+  L.SetOutput(&logbuf)
+  defer L.SetOutput(os.Stderr)
+
+  for _, test := range tests {
+    if test.name == "helper" && runtime.GOOS == "windows" {
+      continue
+    }
+    repo := &Repo{ Url: srv.URL }
+    if len(test.auth) > 0 {
+      repo.Auth = &RepoAuth{}
+      if err := yaml.Unmarshal([]byte(test.auth), repo.Auth); err != nil {
+        t.Fatalf("%s: %v", test.name, err)
+      }
+    }
+    err := repo.Update()
+    if test.ok && err != nil {
+      t.Errorf("%s: %v", test.name, err)
+    } else if !test.ok && (err == nil || !strings.Contains(err.Error(), "401")) {
+      t.Errorf("%s: expected 401 error; got %v", test.name, err)
+    }
+  }
+
+  // it's an error to refer to a variable that is not set
+  repo := &Repo{ Url: srv.URL, Auth: &RepoAuth{ Token: "$FONTCTRL_TEST_UNSET" } }
+  if err := repo.Update(); err == nil ||
+     !strings.Contains(err.Error(), "FONTCTRL_TEST_UNSET is not set") {
+    t.Errorf("expected error for unset variable; got %v", err)
+  }
+
+  // credentials are only sent to the host of the repo
+  repo = &Repo{ Url: srv.URL, Auth: &RepoAuth{ Token: token } }
+  src, _ := repo.Source()
+  req, _ := http.NewRequest("GET", "https://example.com/fonts.zip", nil)
+  if err := repo.Auth.authorize(req, src.(*httpRepoSource).host); err != nil {
+    t.Fatal(err)
+  }
+  if s := req.Header.Get("Authorization"); len(s) > 0 {
+    t.Errorf("token sent to other host")
+  }
+
+  // nor to the host of an archive of a local repo
+  var archiveAuth string
+  archiveSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    archiveAuth = r.Header.Get("Authorization")
+    io.WriteString(w, "archive")
+  }))
+  defer archiveSrv.Close()
+  for _, auth := range []*RepoAuth{
+    { Token: token },
+    { Username: "fonts", Password: password },
+  } {
+    repo = &Repo{ Url: tmpdir, Auth: auth }
+    src, _ = repo.Source()
+    rc, err := src.OpenArchive(archiveSrv.URL + "/fonts.zip")
+    if err != nil {
+      t.Fatal(err)
+    }
+    rc.Close()
+    if len(archiveAuth) > 0 {
+      t.Errorf("credentials of local repo sent to archive host: %q", archiveAuth)
+    }
+  }
+
+  // secrets are not logged, including passwords in repo URLs
+  repo = &Repo{ Url: "http://fonts:" + password + "@" + host }
+  repo.Update()
+  L.Printf("%s", repo)
+  if strings.Contains(logbuf.String(), token) ||
+     strings.Contains(logbuf.String(), password) {
+    t.Errorf("secret found in log:\n%s", logbuf.String())
+  }
+}
+
+
+func TestExpandSecret(t *testing.T) {
+  os.Setenv("FONTCTRL_TEST_SECRET", "s3cr3t")
+  defer os.Unsetenv("FONTCTRL_TEST_SECRET")
+  tests := []struct {
+    in  string
+    out string
+  }{
+    { "$FONTCTRL_TEST_SECRET", "s3cr3t" },
+    { "${FONTCTRL_TEST_SECRET}", "s3cr3t" },
+    { "pa$$word", "pa$$word" },
+    { "pa$FONTCTRL_TEST_SECRET", "pa$FONTCTRL_TEST_SECRET" },
+    { "$FONTCTRL_TEST_SECRET-x", "$FONTCTRL_TEST_SECRET-x" },
+    { "${FONTCTRL_TEST_SECRET}x", "${FONTCTRL_TEST_SECRET}x" },
+    { "$", "$" },
+    { "${}", "${}" },
+    { "$1abc", "$1abc" },
+  }
+  for _, test := range tests {
+    out, err := expandSecret(test.in)
+    if err != nil || out != test.out {
+      t.Errorf("%q: got %q, %v ; expected %q", test.in, out, err, test.out)
+    }
+  }
+  if _, err := expandSecret("${FONTCTRL_TEST_UNSET}"); err == nil {
+    t.Errorf("expected error for unset variable")
+  }
+}
+
+
+func TestParseNetrc(t *testing.T) {
+  netrc := `
+machine fonts.example.com login alice password a1
+machine other.example.com
+  login bob
+  password b1
+default
+  login anon password anon
+`
+  tests := []struct {
+    host     string
+    username string
+    password string
+  }{
+    { "fonts.example.com", "alice", "a1" },
+    { "other.example.com", "bob", "b1" },
+    { "example.com", "anon", "anon" },
+  }
+  for _, test := range tests {
+    creds, err := parseNetrc(strings.NewReader(netrc), test.host)
+    if err != nil {
+      t.Fatal(err)
+    }
+    if creds == nil || creds.Username != test.username ||
+       creds.Password != test.password {
+      t.Errorf("%s: got %+v ; expected %s %s",
+        test.host, creds, test.username, test.password)
+    }
+  }
+  creds, _ := parseNetrc(strings.NewReader("machine a login x"), "b")
+  if creds != nil {
+    t.Errorf("expected no credentials without default entry; got %+v", creds)
+  }
+}
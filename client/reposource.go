@@ -0,0 +1,462 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "net/http"
+  "net/url"
+  "os"
+  Path "path"
+  "path/filepath"
+  "runtime"
+  "strings"
+)
+
+// RepoSource provides access to the contents of a repository, e.g. over
+// HTTP. A source is created for a repo URL by the RepoSourceFactory
+// registered for the scheme of the URL. A URL without a scheme is the path
+// of a directory.
+//
+type RepoSource interface {
+  // FetchIndex reads index.json of the repo into index
+  FetchIndex(index *RepoIndex) error
+
+  // FetchVersionInfo reads <font>/<font>-<version>.json into fvi
+  FetchVersionInfo(fid string, ver *Version, fvi *FontVersionInfo) error
+
+  // ArchiveURL returns the URL of <font>/<font>-<version>.zip
+  ArchiveURL(fid string, ver *Version) string
+
+  // OpenArchive opens the archive at url, which is either a URL returned by
+  // ArchiveURL or the archive_url of version info
+  OpenArchive(url string) (io.ReadCloser, error)
+}
+
+// RepoLister is implemented by sources that can list the files in a
+// directory of the repo
+//
+type RepoLister interface {
+  // List returns the names of the files in dir, relative to dir
+  List(dir string) ([]string, error)
+}
+
+// RepoPublisher is implemented by sources that can write files to the repo
+//
+type RepoPublisher interface {
+  // Publish writes the contents of r to the file at path in the repo
+  Publish(path string, r io.Reader) error
+}
+
+// RepoSourceFactory creates a source for url, which has the scheme the
+// factory was registered for. repo is the repo the source is created for
+// and provides settings like repo.Auth. repo is nil when a source is opened
+// without a repo.
+//
+type RepoSourceFactory func(repo *Repo, url string) (RepoSource, error)
+
+var repoSources = make(map[string]RepoSourceFactory)
+
+// RegisterRepoSource makes repos with URLs of scheme (e.g. "https")
+// available through sources created by f
+//
+func RegisterRepoSource(scheme string, f RepoSourceFactory) {
+  repoSources[scheme] = f
+}
+
+func init() {
+  RegisterRepoSource("http", newHTTPRepoSource)
+  RegisterRepoSource("https", newHTTPRepoSource)
+  RegisterRepoSource("github", newGithubRepoSource)
+  RegisterRepoSource("file", newFileRepoSource)
+}
+
+
+// OpenRepoSource creates a source for the repo at url, configured by repo
+// which may be nil
+//
+func OpenRepoSource(repo *Repo, url string) (RepoSource, error) {
+  scheme := repoURLScheme(url)
+  if len(scheme) == 0 {
+    dir, err := filepath.Abs(url)
+    if err != nil {
+      return nil, err
+    }
+    return &fileRepoSource{ dir: dir, repo: repo }, nil
+  }
+  f, ok := repoSources[scheme]
+  if !ok {
+    return nil, fmt.Errorf(
+      "can not understand repo url \"%s\"; unknown protocol", url)
+  }
+  return f(repo, url)
+}
+
+
+// repoURLScheme returns the scheme of url, e.g. "https", or "" if url is a
+// file path. Windows drive letters (e.g. "C:\fonts") are not schemes.
+//
+func repoURLScheme(url string) string {
+  p := strings.IndexByte(url, ':')
+  if p < 2 || strings.ContainsAny(url[:p], `/\`) {
+    return ""
+  }
+  return url[:p]
+}
+
+
+// httpRepoSource is a repo served over HTTP(S) from baseURL
+type httpRepoSource struct {
+  baseURL string     // ends with "/"
+  host    string     // host of baseURL
+  auth    *RepoAuth  // nil if the repo requires no authentication
+  client  *http.Client
+}
+
+func newHTTPRepoSource(repo *Repo, s string) (RepoSource, error) {
+  u, err := url.Parse(s)
+  if err != nil {
+    return nil, err
+  }
+  client, err := repo.httpClient()
+  if err != nil {
+    return nil, err
+  }
+  src := &httpRepoSource{
+    baseURL: withTrailingSlash(s),
+    host:    u.Host,
+    client:  client,
+  }
+  if repo != nil {
+    src.auth = repo.Auth
+  }
+  return src, nil
+}
+
+// newRequest creates a GET request for url, authenticated as configured
+// for the repo
+//
+func (s *httpRepoSource) newRequest(url string) (*http.Request, error) {
+  req, err := http.NewRequest("GET", url, nil)
+  if err != nil {
+    return nil, err
+  }
+  if err := s.auth.authorize(req, s.host); err != nil {
+    return nil, err
+  }
+  return req, nil
+}
+
+// get requests url. Returns an error for responses with a non-2xx status.
+//
+func (s *httpRepoSource) get(url string) (*http.Response, error) {
+  req, err := s.newRequest(url)
+  if err != nil {
+    return nil, err
+  }
+  res, err := s.client.Do(req)
+  if err != nil {
+    return nil, err
+  }
+  if res.StatusCode < 200 || res.StatusCode > 299 {
+    res.Body.Close()
+    return nil, httpStatusError(res, url)
+  }
+  return res, nil
+}
+
+func httpStatusError(res *http.Response, url string) error {
+  return fmt.Errorf("%d %s (GET %s)",
+    res.StatusCode, http.StatusText(res.StatusCode), redactURL(url))
+}
+
+// fetch returns the body of url, using httpCache. A cached copy is used
+// without a request while fresh or when offline, and is otherwise
+// revalidated. If the request fails, e.g. when the network is unavailable,
+// a cached copy is used as well.
+//
+func (s *httpRepoSource) fetch(url string) ([]byte, error) {
+  e := httpCache.Lookup(url)
+  if e != nil && (httpCache.Offline || e.Fresh()) {
+    L.Printf("using cached %s", redactURL(url))
+    return e.Body, nil
+  }
+  if httpCache.Offline {
+    return nil, fmt.Errorf("%s is not cached (offline)", redactURL(url))
+  }
+
+  L.Printf("fetching %s", redactURL(url))
+  req, err := s.newRequest(url)
+  if err != nil {
+    return nil, err
+  }
+  if e != nil {
+    e.addConditions(req)
+  }
+  res, err := s.client.Do(req)
+  if err != nil {
+    if e != nil {
+      L.Printf("using cached %s (%v)", redactURL(url), err)
+      return e.Body, nil
+    }
+    return nil, err
+  }
+  defer res.Body.Close()
+
+  body := []byte(nil)
+  switch {
+  case res.StatusCode == http.StatusNotModified && e != nil:
+    body = e.Body
+    // a 304 response needn't repeat the validators
+    for _, h := range [][2]string{
+      { "ETag", e.ETag }, { "Last-Modified", e.LastModified },
+    } {
+      if len(res.Header.Get(h[0])) == 0 && len(h[1]) > 0 {
+        res.Header.Set(h[0], h[1])
+      }
+    }
+  case res.StatusCode >= 200 && res.StatusCode <= 299:
+    if body, err = ioutil.ReadAll(res.Body); err != nil {
+      return nil, err
+    }
+  default:
+    return nil, httpStatusError(res, url)
+  }
+  if err := httpCache.Store(url, res, body); err != nil {
+    L.Printf("failed to cache %s: %v", redactURL(url), err)
+  }
+  return body, nil
+}
+
+func (s *httpRepoSource) fetchJson(url string, v interface{}) error {
+  body, err := s.fetch(url)
+  if err != nil {
+    return err
+  }
+  if err := json.Unmarshal(body, v); err != nil {
+    return fmt.Errorf("%s: %v", redactURL(url), err)
+  }
+  return nil
+}
+
+func (s *httpRepoSource) FetchIndex(index *RepoIndex) error {
+  return s.fetchJson(s.baseURL + "index.json", index)
+}
+
+func (s *httpRepoSource) FetchVersionInfo(fid string, ver *Version, fvi *FontVersionInfo) error {
+  return s.fetchJson(s.baseURL + fmt.Sprintf("%s/%s-%s.json", fid, fid, ver), fvi)
+}
+
+func (s *httpRepoSource) ArchiveURL(fid string, ver *Version) string {
+  return s.baseURL + fmt.Sprintf("%s/%s-%s.zip", fid, fid, ver)
+}
+
+func (s *httpRepoSource) OpenArchive(url string) (io.ReadCloser, error) {
+  if httpCache.Offline {
+    return nil, fmt.Errorf("can not download %s (offline)", redactURL(url))
+  }
+  res, err := s.get(url)
+  if err != nil {
+    return nil, err
+  }
+  return res.Body, nil
+}
+
+
+// newGithubRepoSource creates a source for "github:user/repo/path#branch",
+// which is served over HTTPS by raw.githubusercontent.com
+//
+func newGithubRepoSource(repo *Repo, url string) (RepoSource, error) {
+  base, err := parseGithubRepoUrl(url[strings.IndexByte(url, ':') + 1:])
+  if err != nil {
+    return nil, err
+  }
+  return newHTTPRepoSource(repo, base)
+}
+
+
+func withTrailingSlash(s string) string {
+  if s[len(s)-1] != '/' {
+    return s + "/"
+  }
+  return s
+}
+
+// parseGithubRepoUrl returns the base URL of the files of a github repo
+func parseGithubRepoUrl(s string) (string, error) {
+  // expect "user/repo/path?#branch?"
+  br := "master"
+  var ps string
+  i, p := 0, 0
+  for i < len(s) {
+    if s[i] == '/' {
+      if p == 1 {
+        ps = s[i:]
+        s = s[:i]
+        if x := strings.IndexByte(ps, '#'); x > -1 {
+          br = ps[x+1:]
+          ps = ps[:x]
+        }
+        break
+      }
+      p++
+    }
+    i++
+  }
+
+  if p == 0 {
+    // no slash found
+    return s, fmt.Errorf(
+      "invalid github repo url \"%s\"; expected user/repo", s)
+  }
+
+  if len(ps) == 0 {
+    if p = strings.IndexByte(s, '#'); p > -1 {
+      br = s[p+1:]
+      s = s[:p]
+    }
+  }
+
+  s = "https://raw.githubusercontent.com/" + Path.Join(s, br, ps)
+
+  return s, nil
+}
+
+
+// fileRepoSource is a repo in a local directory, or a directory on a
+// network share
+type fileRepoSource struct {
+  dir  string
+  repo *Repo  // for archives in other kinds of repos; may be nil
+}
+
+// newFileRepoSource creates a source for "file:///path/to/repo".
+// On Windows, "file://server/share/repo" names a UNC path.
+//
+func newFileRepoSource(repo *Repo, s string) (RepoSource, error) {
+  u, err := url.Parse(s)
+  if err != nil {
+    return nil, err
+  }
+  p := u.Path
+  if runtime.GOOS == "windows" {
+    if len(u.Host) > 0 && u.Host != "localhost" {
+      p = "//" + u.Host + p
+    } else if len(p) > 2 && p[0] == '/' && p[2] == ':' {
+      p = p[1:]  // "/C:/fonts"
+    }
+  } else if len(u.Host) > 0 && u.Host != "localhost" {
+    return nil, fmt.Errorf(
+      "invalid repo url \"%s\"; remote hosts are not supported", s)
+  }
+  if len(p) == 0 {
+    return nil, fmt.Errorf("invalid repo url \"%s\"; missing path", s)
+  }
+  return &fileRepoSource{
+    dir:  filepath.Clean(filepath.FromSlash(p)),
+    repo: repo,
+  }, nil
+}
+
+// path returns the filename of path in the repo
+func (s *fileRepoSource) path(path string) (string, error) {
+  filename := filepath.Join(s.dir, filepath.FromSlash(path))
+  if rel, err := filepath.Rel(s.dir, filename); err != nil ||
+     rel == ".." || strings.HasPrefix(rel, ".." + string(filepath.Separator)) {
+    return "", fmt.Errorf("path \"%s\" is outside of repo %s", path, s.dir)
+  }
+  return filename, nil
+}
+
+func (s *fileRepoSource) readJson(path string, v interface{}) error {
+  filename, err := s.path(path)
+  if err != nil {
+    return err
+  }
+  L.Printf("reading %s", filename)
+  data, err := ioutil.ReadFile(filename)
+  if err != nil {
+    return err
+  }
+  if err := json.Unmarshal(data, v); err != nil {
+    return fmt.Errorf("%s: %v", filename, err)
+  }
+  return nil
+}
+
+func (s *fileRepoSource) FetchIndex(index *RepoIndex) error {
+  return s.readJson("index.json", index)
+}
+
+func (s *fileRepoSource) FetchVersionInfo(fid string, ver *Version, fvi *FontVersionInfo) error {
+  return s.readJson(fmt.Sprintf("%s/%s-%s.json", fid, fid, ver), fvi)
+}
+
+// ArchiveURL returns the path of the archive relative to the repo directory,
+// so that the path recorded in a lock file is the same in every checkout
+//
+func (s *fileRepoSource) ArchiveURL(fid string, ver *Version) string {
+  return fmt.Sprintf("%s/%s-%s.zip", fid, fid, ver)
+}
+
+// OpenArchive opens the archive at url, which may also be the URL of an
+// archive in another kind of repo, e.g. "https://". A relative path is
+// relative to the repo directory. Only credentials from an auth helper or
+// netrc file are sent along with requests for such archives.
+//
+func (s *fileRepoSource) OpenArchive(url string) (io.ReadCloser, error) {
+  if scheme := repoURLScheme(url); len(scheme) > 0 {
+    src, err := OpenRepoSource(s.repo, url)
+    if err != nil {
+      return nil, err
+    }
+    switch src := src.(type) {
+    case *fileRepoSource:
+      return os.Open(src.dir)  // file URL
+    case *httpRepoSource:
+      // the host of the archive is not the host of the repo, so the token or
+      // username and password of the repo must not be sent to it
+      src.host = ""
+    }
+    return src.OpenArchive(url)
+  }
+  if !filepath.IsAbs(url) {
+    filename, err := s.path(url)
+    if err != nil {
+      return nil, err
+    }
+    url = filename
+  }
+  return os.Open(url)
+}
+
+func (s *fileRepoSource) List(dir string) ([]string, error) {
+  dirname, err := s.path(dir)
+  if err != nil {
+    return nil, err
+  }
+  files, err := ioutil.ReadDir(dirname)
+  if err != nil {
+    return nil, err
+  }
+  var names []string
+  for _, f := range files {
+    if !f.IsDir() {
+      names = append(names, f.Name())
+    }
+  }
+  return names, nil
+}
+
+func (s *fileRepoSource) Publish(path string, r io.Reader) error {
+  filename, err := s.path(path)
+  if err != nil {
+    return err
+  }
+  data, err := ioutil.ReadAll(r)
+  if err != nil {
+    return err
+  }
+  return writeFileAtomic(filename, data, 0644)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+  "io"
+  "io/ioutil"
+  "net/http"
+  "net/http/httptest"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+func TestHTTPRepoSource(t *testing.T) {
+  files := map[string]string{
+    "/fonts/index.json":
+      `{"fonts":{"inter":{"name":"Inter","versions":["3.19","3.18"]}}}`,
+    "/fonts/inter/inter-3.19.json":
+      `{"version":"3.19","checksum":"abc","name":"Inter","styles":["Regular"]}`,
+    "/fonts/inter/inter-3.19.zip": "archive",
+  }
+  srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    data, ok := files[r.URL.Path]
+    if !ok {
+      http.NotFound(w, r)
+      return
+    }
+    io.WriteString(w, data)
+  }))
+  defer srv.Close()
+
+  repo := &Repo{ Url: srv.URL + "/fonts" }
+  if err := repo.Update(); err != nil {
+    t.Fatal(err)
+  }
+  findex := repo.Index.Fonts["inter"]
+  if findex == nil || findex.Family != "Inter" || len(findex.Versions) != 2 {
+    t.Fatalf("unexpected index %+v", repo.Index)
+  }
+  fvi, err := findex.GetVersionInfoAt(0)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if fvi.Checksum != "abc" {
+    t.Errorf("checksum = %q ; expected \"abc\"", fvi.Checksum)
+  }
+
+  url, err := findex.GetArchiveUrlAt(0, fvi)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if expected := srv.URL + "/fonts/inter/inter-3.19.zip"; url != expected {
+    t.Errorf("archive url = %s ; expected %s", url, expected)
+  }
+  src, _ := repo.Source()
+  rc, err := src.OpenArchive(url)
+  if err != nil {
+    t.Fatal(err)
+  }
+  data, err := ioutil.ReadAll(rc)
+  rc.Close()
+  if err != nil || string(data) != "archive" {
+    t.Errorf("archive = %q, %v ; expected \"archive\"", data, err)
+  }
+
+  if _, err := src.OpenArchive(srv.URL + "/fonts/missing.zip"); err == nil ||
+     !strings.Contains(err.Error(), "404") {
+    t.Errorf("expected 404 error for missing archive; got %v", err)
+  }
+}
+
+
+// testRepoSource serves an empty index
+type testRepoSource struct {
+  url string
+}
+
+func (s *testRepoSource) FetchIndex(index *RepoIndex) error {
+  index.Fonts = map[string]*FontIndex{}
+  return nil
+}
+
+func (s *testRepoSource) FetchVersionInfo(fid string, ver *Version, fvi *FontVersionInfo) error {
+  return nil
+}
+
+func (s *testRepoSource) ArchiveURL(fid string, ver *Version) string {
+  return s.url + "/" + fid + ".zip"
+}
+
+func (s *testRepoSource) OpenArchive(url string) (io.ReadCloser, error) {
+  return ioutil.NopCloser(strings.NewReader("")), nil
+}
+
+
+func TestRegisterRepoSource(t *testing.T) {
+  RegisterRepoSource("fontctrl-test", func(repo *Repo, url string) (RepoSource, error) {
+    return &testRepoSource{ url: url }, nil
+  })
+  defer delete(repoSources, "fontctrl-test")
+
+  repo := &Repo{ Url: "fontctrl-test:fonts" }
+  if err := repo.Update(); err != nil {
+    t.Fatal(err)
+  }
+  if src, _ := repo.Source(); src.(*testRepoSource).url != repo.Url {
+    t.Errorf("source not created from repo url")
+  }
+
+  if _, err := OpenRepoSource(nil, "gopher://fonts"); err == nil {
+    t.Errorf("expected error for unknown protocol")
+  }
+}
+
+
+func TestGithubRepoSource(t *testing.T) {
+  tests := []struct {
+    url      string
+    expected string
+  }{
+    { "github:rsms/fonts", "https://raw.githubusercontent.com/rsms/fonts/master/" },
+    { "github:rsms/fonts#dev", "https://raw.githubusercontent.com/rsms/fonts/dev/" },
+    { "github:rsms/fonts/repo#dev",
+      "https://raw.githubusercontent.com/rsms/fonts/dev/repo/" },
+  }
+  for _, test := range tests {
+    src, err := OpenRepoSource(nil, test.url)
+    if err != nil {
+      t.Errorf("%s: %v", test.url, err)
+      continue
+    }
+    if base := src.(*httpRepoSource).baseURL; base != test.expected {
+      t.Errorf("%s: base url = %s ; expected %s", test.url, base, test.expected)
+    }
+  }
+  if _, err := OpenRepoSource(nil, "github:rsms"); err == nil {
+    t.Errorf("expected error for github url without repo")
+  }
+}
+
+
+func TestFileRepoSource(t *testing.T) {
+  tmpdir, err := ioutil.TempDir("", "fontctrl-test")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(tmpdir)
+
+  repodir := filepath.Join(tmpdir, "fonts-repo")
+  writeTestFile(t, filepath.Join(repodir, "index.json"),
+    `{"fonts":{"inter":{"name":"Inter","versions":["3.19"]}}}`)
+  writeTestFile(t, filepath.Join(repodir, "inter", "inter-3.19.json"),
+    `{"version":"3.19","checksum":"abc","name":"Inter","styles":["Regular"]}`)
+  writeTestFile(t, filepath.Join(repodir, "inter", "inter-3.19.zip"), "archive")
+
+  // a relative path in a config file is relative to the config file
+  configFile := filepath.Join(tmpdir, "fontctrl.yml")
+  writeTestFile(t, configFile, "repos:\n  - url: ./fonts-repo\nfonts: {}\n")
+  c := &Config{}
+  if err := c.LoadFile(configFile); err != nil {
+    t.Fatal(err)
+  }
+
+  for _, repo := range []*Repo{
+    c.Repos[0],
+    &Repo{ Url: repodir },
+    &Repo{ Url: "file://" + filepath.ToSlash(repodir) },
+  } {
+    if err := repo.Update(); err != nil {
+      t.Errorf("%s: %v", repo.Url, err)
+      continue
+    }
+    findex := repo.Index.Fonts["inter"]
+    if findex == nil || findex.Family != "Inter" {
+      t.Errorf("%s: unexpected index %+v", repo.Url, repo.Index)
+      continue
+    }
+    fvi, err := findex.GetVersionInfoAt(0)
+    if err != nil {
+      t.Errorf("%s: %v", repo.Url, err)
+      continue
+    }
+    url, _ := findex.GetArchiveUrlAt(0, fvi)
+    src, _ := repo.Source()
+    rc, err := src.OpenArchive(url)
+    if err != nil {
+      t.Errorf("%s: %v", repo.Url, err)
+      continue
+    }
+    data, _ := ioutil.ReadAll(rc)
+    rc.Close()
+    if string(data) != "archive" {
+      t.Errorf("%s: archive = %q ; expected \"archive\"", repo.Url, data)
+    }
+  }
+
+  src, err := OpenRepoSource(nil, repodir)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  // a relative archive_url is relative to the repo, not the working dir
+  rc, err := src.OpenArchive("inter/inter-3.19.zip")
+  if err != nil {
+    t.Fatal(err)
+  }
+  data, _ := ioutil.ReadAll(rc)
+  rc.Close()
+  if string(data) != "archive" {
+    t.Errorf("relative archive = %q ; expected \"archive\"", data)
+  }
+  if _, err := src.OpenArchive("../fontctrl.yml"); err == nil {
+    t.Errorf("expected error for archive outside of the repo")
+  }
+  if err := src.(RepoPublisher).Publish("roboto/roboto-2.0.json",
+                                        strings.NewReader("{}")); err != nil {
+    t.Fatal(err)
+  }
+  names, err := src.(RepoLister).List("roboto")
+  if err != nil || len(names) != 1 || names[0] != "roboto-2.0.json" {
+    t.Errorf("List(\"roboto\") = %q, %v ; expected [\"roboto-2.0.json\"]", names, err)
+  }
+  if err := src.(RepoPublisher).Publish("../outside.json",
+                                        strings.NewReader("{}")); err == nil {
+    t.Errorf("expected error for publishing outside of the repo")
+  }
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+  "encoding/binary"
+  "errors"
+  "fmt"
+  "io"
+  "os"
+  "unicode/utf16"
+
+  "github.com/ConradIrwin/font/sfnt"
+)
+
+// Minimal reading of SFNT (TrueType/OpenType) structures directly from
+// an io.ReaderAt, without parsing the whole font.
+// See https://docs.microsoft.com/en-us/typography/opentype/spec/otff
+
+type sfntTableRecord struct {
+  Offset uint32
+  Length uint32
+}
+
+type sfntTableDir map[string]sfntTableRecord  // keyed by tag, e.g. "head"
+
+// size limits of the tables we read, to protect against malformed fonts
+const (
+  maxNameTableLength = 1 << 20
+  headTableLength    = 54
+)
+
+
+// readSfntCollection returns the offsets of the fonts in a font collection
+// (.ttc or .otc file), or nil if r does not contain a collection
+//
+func readSfntCollection(r io.ReaderAt) ([]int64, error) {
+  var hdr [12]byte
+  if _, err := r.ReadAt(hdr[:], 0); err != nil {
+    if err == io.EOF {
+      return nil, nil  // too short to be a collection
+    }
+    return nil, err
+  }
+  if string(hdr[:4]) != "ttcf" {
+    return nil, nil
+  }
+  numFonts := binary.BigEndian.Uint32(hdr[8:])
+  if numFonts == 0 || numFonts > 0xffff {
+    return nil, fmt.Errorf("invalid number of fonts in collection (%d)", numFonts)
+  }
+  buf := make([]byte, numFonts * 4)
+  if _, err := r.ReadAt(buf, 12); err != nil {
+    return nil, err
+  }
+  offsets := make([]int64, numFonts)
+  for i := range offsets {
+    offsets[i] = int64(binary.BigEndian.Uint32(buf[i * 4:]))
+  }
+  return offsets, nil
+}
+
+
+// readSfntTableDir reads the table directory of a font starting at offset
+func readSfntTableDir(r io.ReaderAt, offset int64) (sfntTableDir, error) {
+  var hdr [12]byte
+  if _, err := r.ReadAt(hdr[:], offset); err != nil {
+    return nil, err
+  }
+  switch string(hdr[:4]) {
+    case "\x00\x01\x00\x00", "OTTO", "true", "typ1":  // ok
+    default:
+      return nil, fmt.Errorf("not an sfnt font (signature %q)", hdr[:4])
+  }
+  numTables := int(binary.BigEndian.Uint16(hdr[4:]))
+
+  buf := make([]byte, numTables * 16)
+  if _, err := r.ReadAt(buf, offset + 12); err != nil {
+    return nil, err
+  }
+  dir := make(sfntTableDir, numTables)
+  for i := 0; i < numTables; i++ {
+    rec := buf[i * 16:]
+    dir[string(rec[:4])] = sfntTableRecord{
+      Offset: binary.BigEndian.Uint32(rec[8:]),
+      Length: binary.BigEndian.Uint32(rec[12:]),
+    }
+  }
+  return dir, nil
+}
+
+
+// readTable reads the entire table with tag from r. Tables larger than
+// maxLength, or which extend past the end of r, are rejected.
+//
+func (dir sfntTableDir) readTable(r io.ReaderAt, tag string, maxLength uint32) ([]byte, error) {
+  rec, ok := dir[tag]
+  if !ok {
+    return nil, fmt.Errorf("missing %s table", tag)
+  }
+  if rec.Length > maxLength {
+    return nil, fmt.Errorf("%s table too large (%d bytes)", tag, rec.Length)
+  }
+  if size := readerSize(r); size >= 0 &&
+     int64(rec.Offset) + int64(rec.Length) > size {
+    return nil, fmt.Errorf("%s table out of bounds", tag)
+  }
+  buf := make([]byte, rec.Length)
+  if _, err := r.ReadAt(buf, int64(rec.Offset)); err != nil {
+    return nil, err
+  }
+  return buf, nil
+}
+
+
+// readerSize returns the size of r, or -1 if it is unknown
+func readerSize(r io.ReaderAt) int64 {
+  switch r := r.(type) {
+  case interface{ Size() int64 }:  // e.g. bytes.Reader
+    return r.Size()
+  case interface{ Stat() (os.FileInfo, error) }:  // e.g. os.File
+    if fi, err := r.Stat(); err == nil {
+      return fi.Size()
+    }
+  }
+  return -1
+}
+
+
+// Fixed is a 16.16 fixed-point number, as used by head.fontRevision
+type Fixed uint32
+
+// Float returns f as a floating-point number
+func (f Fixed) Float() float64 {
+  return float64(int32(f)) / 65536
+}
+
+
+// readHeadFontRevision reads fontRevision from the head table of the font
+// starting at offset in r
+func readHeadFontRevision(r io.ReaderAt, offset int64) (Fixed, error) {
+  dir, err := readSfntTableDir(r, offset)
+  if err != nil {
+    return 0, err
+  }
+  return dir.readFontRevision(r)
+}
+
+
+func (dir sfntTableDir) readFontRevision(r io.ReaderAt) (Fixed, error) {
+  head, err := dir.readTable(r, "head", headTableLength)
+  if err != nil {
+    return 0, err
+  }
+  if len(head) < 8 {
+    return 0, errors.New("head table too short")
+  }
+  return Fixed(binary.BigEndian.Uint32(head[4:])), nil
+}
+
+
+// sfntName is a decoded record of the name table
+type sfntName struct {
+  PlatformID uint16
+  EncodingID uint16
+  LanguageID uint16
+  NameID     sfnt.NameID
+  Value      string
+}
+
+// sfntFontInfo is what we need to know about a font to index it
+type sfntFontInfo struct {
+  Names       []sfntName
+  Revision    Fixed
+  HasRevision bool  // false if the font has no (valid) head table
+}
+
+
+// readSfntFontInfo reads the name and head tables of the font starting at
+// offset in r, without reading any other part of the font
+//
+func readSfntFontInfo(r io.ReaderAt, offset int64) (*sfntFontInfo, error) {
+  dir, err := readSfntTableDir(r, offset)
+  if err != nil {
+    return nil, err
+  }
+  info := &sfntFontInfo{}
+  if info.Names, err = dir.readNames(r); err != nil {
+    return nil, err
+  }
+  info.Revision, err = dir.readFontRevision(r)
+  info.HasRevision = err == nil
+  return info, nil
+}
+
+
+func (dir sfntTableDir) readNames(r io.ReaderAt) ([]sfntName, error) {
+  b, err := dir.readTable(r, "name", maxNameTableLength)
+  if err != nil {
+    return nil, err
+  }
+  if len(b) < 6 {
+    return nil, errors.New("name table too short")
+  }
+  count := int(binary.BigEndian.Uint16(b[2:]))
+  storage := int(binary.BigEndian.Uint16(b[4:]))
+  if len(b) < 6 + count * 12 {
+    return nil, errors.New("name table too short")
+  }
+  names := make([]sfntName, 0, count)
+  for i := 0; i < count; i++ {
+    rec := b[6 + i * 12:]
+    length := int(binary.BigEndian.Uint16(rec[8:]))
+    start := storage + int(binary.BigEndian.Uint16(rec[10:]))
+    if start + length > len(b) {
+      continue  // invalid; ignore
+    }
+    n := sfntName{
+      PlatformID: binary.BigEndian.Uint16(rec[0:]),
+      EncodingID: binary.BigEndian.Uint16(rec[2:]),
+      LanguageID: binary.BigEndian.Uint16(rec[4:]),
+      NameID:     sfnt.NameID(binary.BigEndian.Uint16(rec[6:])),
+    }
+    n.Value = decodeSfntName(n.PlatformID, b[start:start + length])
+    names = append(names, n)
+  }
+  return names, nil
+}
+
+
+// decodeSfntName decodes the value of a name record
+func decodeSfntName(platformID uint16, b []byte) string {
+  switch platformID {
+    case 0, 3:  // Unicode, Windows
+      u := make([]uint16, len(b) / 2)
+      for i := range u {
+        u[i] = binary.BigEndian.Uint16(b[i * 2:])
+      }
+      return string(utf16.Decode(u))
+    case 1:  // Macintosh; assume the Roman encoding
+      rv := make([]rune, len(b))
+      for i, c := range b {
+        if c < 0x80 {
+          rv[i] = rune(c)
+        } else {
+          rv[i] = macRomanHigh[c - 0x80]
+        }
+      }
+      return string(rv)
+  }
+  return string(b)
+}
+
+// macRomanHigh maps the upper half of the Mac OS Roman encoding to Unicode
+var macRomanHigh = []rune(
+  "ÄÅÇÉÑÖÜáàâäãåçéèêëíìîïñóòôöõúùûü" +
+  "†°¢£§•¶ß®©™´¨≠ÆØ∞±≤≥¥µ∂∑∏π∫ªºΩæø" +
+  "¿¡¬√ƒ≈∆«»…\u00a0ÀÃÕŒœ–—“”‘’÷◊ÿŸ⁄€‹›ﬁﬂ" +
+  "‡·‚„‰ÂÊÁËÈÍÎÏÌÓÔ\uf8ffÒÚÛÙıˆ˜¯˘˙˚¸˝˛ˇ")
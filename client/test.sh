@@ -3,4 +3,4 @@ set -e
 cd "$(dirname "$0")"
 source ../init.sh
 
-go test
+go test "$@"
@@ -0,0 +1,264 @@
+package main
+
+import (
+  "encoding/xml"
+  "fmt"
+  "io"
+  "math"
+  "os"
+  "path/filepath"
+  "strconv"
+  "strings"
+
+  "github.com/ConradIrwin/font/sfnt"
+)
+
+// Reading of TTX files, the XML representation of sfnt fonts used by
+// fontTools. Only the name and head tables are read.
+// See https://fonttools.readthedocs.io/en/latest/ttx.html
+
+type ttxHead struct {
+  FontRevision *struct {
+    Value string `xml:"value,attr"`
+  } `xml:"fontRevision"`
+}
+
+type ttxName struct {
+  Records []struct {
+    NameID     string `xml:"nameID,attr"`
+    PlatformID string `xml:"platformID,attr"`
+    EncodingID string `xml:"platEncID,attr"`
+    LanguageID string `xml:"langID,attr"`
+    Value      string `xml:",chardata"`
+  } `xml:"namerecord"`
+}
+
+
+// parseTTXFile parses the TTX file filename. Returns one FontFile for each
+// font of a collection (<ttCollection>).
+//
+// A TTX file that lacks a name or head table, like the per-table files
+// written by "ttx -s" or a dump of selected tables, describes no font and
+// yields no FontFiles.
+//
+func parseTTXFile(filename string) ([]*FontFile, error) {
+  infos, err := readTTXFontInfo(filename)
+  if err != nil {
+    return nil, err
+  }
+  var fonts []*FontFile
+  for i, info := range infos {
+    if info == nil {
+      continue
+    }
+    f := &FontFile{ Filename: filename, Face: i }
+    if err := f.setInfo(info); err != nil {
+      if len(infos) > 1 {
+        err = fmt.Errorf("face %d: %v", i, err)
+      }
+      return nil, err
+    }
+    fonts = append(fonts, f)
+  }
+  return fonts, nil
+}
+
+
+// readTTXFontInfo reads the name and head tables of each font in the TTX
+// file filename. The info of a font without a name or head table is nil.
+//
+func readTTXFontInfo(filename string) ([]*sfntFontInfo, error) {
+  fp, err := os.Open(filename)
+  if err != nil {
+    return nil, err
+  }
+  defer fp.Close()
+
+  d := xml.NewDecoder(fp)
+  root, err := ttxNextElement(d)
+  if err != nil {
+    return nil, fmt.Errorf("not a TTX file (%v)", err)
+  }
+  dir := filepath.Dir(filename)
+
+  switch root.Name.Local {
+  case "ttFont":
+    info, err := readTTXFont(d, dir, false)
+    if err != nil {
+      return nil, err
+    }
+    return []*sfntFontInfo{ info }, nil
+
+  case "ttCollection":
+    var infos []*sfntFontInfo
+    for {
+      el, err := ttxNextElement(d)
+      if err == io.EOF {
+        return infos, nil  // end of ttCollection
+      }
+      if err != nil {
+        return nil, err
+      }
+      if el.Name.Local != "ttFont" {
+        if err := d.Skip(); err != nil {
+          return nil, err
+        }
+        continue
+      }
+      info, err := readTTXFont(d, dir, true)
+      if err != nil {
+        return nil, fmt.Errorf("face %d: %v", len(infos), err)
+      }
+      infos = append(infos, info)
+    }
+  }
+  return nil, fmt.Errorf("not a TTX file (root element <%s>)", root.Name.Local)
+}
+
+
+// readTTXFont reads the tables of a <ttFont> element, the start of which
+// has been read from d, and stops once both the name and head tables have
+// been read. The rest of the element is then skipped if skipRest is true.
+// Tables stored in separate files (src attribute) are read from dir.
+// Returns nil if the font lacks a name or head table.
+//
+func readTTXFont(d *xml.Decoder, dir string, skipRest bool) (*sfntFontInfo, error) {
+  var head *ttxHead
+  var name *ttxName
+
+  for {
+    if head != nil && name != nil {
+      if skipRest {
+        if err := d.Skip(); err != nil {
+          return nil, err
+        }
+      }
+      break
+    }
+    el, err := ttxNextElement(d)
+    if err == io.EOF {
+      break  // end of ttFont
+    }
+    if err != nil {
+      return nil, err
+    }
+
+    var v interface{}
+    switch el.Name.Local {
+      case "head": head = &ttxHead{}; v = head
+      case "name": name = &ttxName{}; v = name
+      default:
+        if err := d.Skip(); err != nil {
+          return nil, err
+        }
+        continue
+    }
+
+    src := ""
+    for _, a := range el.Attr {
+      if a.Name.Local == "src" {
+        src = a.Value
+      }
+    }
+    if len(src) == 0 {
+      err = d.DecodeElement(v, &el)
+    } else if err = d.Skip(); err == nil {
+      err = readTTXTableFile(filepath.Join(dir, src), el.Name.Local, v)
+    }
+    if err != nil {
+      return nil, fmt.Errorf("%s table: %v", el.Name.Local, err)
+    }
+  }
+
+  if head == nil || name == nil {
+    return nil, nil
+  }
+  return ttxFontInfo(head, name)
+}
+
+
+// readTTXTableFile decodes table tag of the TTX file filename into v.
+// This is used for fonts dumped with "ttx -s", which writes each table to
+// a separate file.
+//
+func readTTXTableFile(filename, tag string, v interface{}) error {
+  fp, err := os.Open(filename)
+  if err != nil {
+    return err
+  }
+  defer fp.Close()
+
+  d := xml.NewDecoder(fp)
+  root, err := ttxNextElement(d)
+  if err != nil || root.Name.Local != "ttFont" {
+    return fmt.Errorf("%s is not a TTX file", filename)
+  }
+  for {
+    el, err := ttxNextElement(d)
+    if err == io.EOF {
+      return fmt.Errorf("%s has no %s table", filename, tag)
+    }
+    if err != nil {
+      return err
+    }
+    if el.Name.Local == tag {
+      return d.DecodeElement(v, &el)
+    }
+    if err := d.Skip(); err != nil {
+      return err
+    }
+  }
+}
+
+
+// ttxNextElement returns the next start element at the current level of d.
+// Returns io.EOF at the end of the enclosing element.
+//
+func ttxNextElement(d *xml.Decoder) (xml.StartElement, error) {
+  for {
+    t, err := d.Token()
+    if err != nil {
+      return xml.StartElement{}, err
+    }
+    switch t := t.(type) {
+      case xml.StartElement: return t, nil
+      case xml.EndElement:   return xml.StartElement{}, io.EOF
+    }
+  }
+}
+
+
+func ttxFontInfo(head *ttxHead, name *ttxName) (*sfntFontInfo, error) {
+  info := &sfntFontInfo{}
+
+  if head.FontRevision != nil {
+    rev, err := strconv.ParseFloat(head.FontRevision.Value, 64)
+    if err != nil {
+      return nil, fmt.Errorf("invalid head.fontRevision %q", head.FontRevision.Value)
+    }
+    info.Revision = Fixed(int32(math.Round(rev * 65536)))
+    info.HasRevision = true
+  }
+
+  for _, rec := range name.Records {
+    var ids [4]uint16
+    for i, s := range []string{
+      rec.PlatformID, rec.EncodingID, rec.LanguageID, rec.NameID,
+    } {
+      // langID is written in hex, e.g. "0x409"
+      n, err := strconv.ParseUint(s, 0, 16)
+      if err != nil {
+        return nil, fmt.Errorf("invalid namerecord attribute %q", s)
+      }
+      ids[i] = uint16(n)
+    }
+    info.Names = append(info.Names, sfntName{
+      PlatformID: ids[0],
+      EncodingID: ids[1],
+      LanguageID: ids[2],
+      NameID:     sfnt.NameID(ids[3]),
+      Value:      strings.TrimSpace(rec.Value),
+    })
+  }
+  return info, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+const testTTXHead = `
+  <head>
+    <tableVersion value="1.0"/>
+    <fontRevision value="3.019"/>
+    <checkSumAdjustment value="0x4d5a1b2c"/>
+    <unitsPerEm value="2816"/>
+  </head>
+`
+
+const testTTXName = `
+  <name>
+    <namerecord nameID="1" platformID="3" platEncID="1" langID="0x409">
+      Inter
+    </namerecord>
+    <namerecord nameID="2" platformID="3" platEncID="1" langID="0x409">
+      Bold
+    </namerecord>
+    <namerecord nameID="5" platformID="3" platEncID="1" langID="0x409">
+      Version 3.019;git-a0b1c2d
+    </namerecord>
+  </name>
+`
+
+func TestParseTTXFile(t *testing.T) {
+  tmpdir, err := ioutil.TempDir("", "fontctrl-test")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(tmpdir)
+
+  glyf := `
+  <GlyphOrder>
+    <GlyphID id="0" name=".notdef"/>
+  </GlyphOrder>
+  <glyf>
+    <TTGlyph name=".notdef"/>
+  </glyf>
+`
+  header := `<?xml version="1.0" encoding="UTF-8"?>` + "\n"
+  files := map[string]string{
+    "Inter-Bold.ttx": header +
+      `<ttFont sfntVersion="\x00\x01\x00\x00" ttLibVersion="3.28">` +
+      glyf + testTTXHead + testTTXName + `</ttFont>`,
+
+    // written by "ttx -s"
+    "Split/Inter-Bold.ttx": header + `<ttFont ttLibVersion="3.28">
+  <GlyphOrder src="Inter-Bold._G_l_y_p_h_O_r_d_e_r.ttx"/>
+  <head src="Inter-Bold._h_e_a_d.ttx"/>
+  <name src="Inter-Bold._n_a_m_e.ttx"/>
+</ttFont>`,
+    "Split/Inter-Bold._h_e_a_d.ttx": header +
+      `<ttFont ttLibVersion="3.28">` + testTTXHead + `</ttFont>`,
+    "Split/Inter-Bold._n_a_m_e.ttx": header +
+      `<ttFont ttLibVersion="3.28">` + testTTXName + `</ttFont>`,
+
+    "Inter.ttx": header + `<ttCollection>
+  <ttFont>` + testTTXHead + testTTXName + `</ttFont>
+  <ttFont>` + testTTXHead + testTTXName + `</ttFont>
+</ttCollection>`,
+
+    "Invalid.ttx": header + `<ttFont><head><fontRevision value="x"/></head>` +
+      testTTXName + `</ttFont>`,
+    "Plist.ttx": header + `<plist version="1.0"></plist>`,
+  }
+  for name, data := range files {
+    writeTestFile(t, filepath.Join(tmpdir, name), data)
+  }
+
+  tests := []struct {
+    filename string
+    faces    int    // -1 = error
+  }{
+    { "Inter-Bold.ttx", 1 },
+    { "Split/Inter-Bold.ttx", 1 },
+    { "Split/Inter-Bold._h_e_a_d.ttx", 0 },
+    { "Split/Inter-Bold._n_a_m_e.ttx", 0 },
+    { "Inter.ttx", 2 },
+    { "Invalid.ttx", -1 },
+    { "Plist.ttx", -1 },
+  }
+  for _, test := range tests {
+    filename := filepath.Join(tmpdir, test.filename)
+    fonts, err := ParseFontFile(filename)
+    if test.faces < 0 {
+      if err == nil {
+        t.Errorf("%s: expected error", test.filename)
+      }
+      continue
+    }
+    if err != nil {
+      t.Errorf("%s: %v", test.filename, err)
+      continue
+    }
+    if len(fonts) != test.faces {
+      t.Errorf("%s: got %d fonts; expected %d", test.filename, len(fonts), test.faces)
+      continue
+    }
+    for i, f := range fonts {
+      if f.Family != "Inter" || f.Style != "Bold" || f.Face != i {
+        t.Errorf("%s: fonts[%d] = %q %q %d ; expected \"Inter\" \"Bold\" %d",
+          test.filename, i, f.Family, f.Style, f.Face, i)
+      }
+      if s := f.Version.String(); s != "3.19.0+git-a0b1c2d" {
+        t.Errorf("%s: fonts[%d].Version = %s ; expected 3.19.0+git-a0b1c2d",
+          test.filename, i, s)
+      }
+    }
+  }
+}
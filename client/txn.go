@@ -0,0 +1,374 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "strings"
+  "time"
+)
+
+// Changes to files in a font directory are made through a transaction (Txn)
+// so that an interrupted install never leaves a half-upgraded family behind:
+//
+//  1. New files are staged in <fontdir>/.fontctrl/txn-<id>/new
+//  2. The journal <fontdir>/.fontctrl/journal.json is written, listing
+//     every file to be installed or removed.
+//  3. Old files are renamed into <fontdir>/.fontctrl/txn-<id>/old and staged
+//     files are renamed into place.
+//  4. Receipts (see ReceiptDB) of installed and removed files are updated.
+//  5. The staging directory and the journal are removed.
+//
+// RecoverTxn inspects the journal left behind by an interrupted process and
+// rolls the transaction back if it never started committing, or forward if
+// it did.
+
+const stateDirName = ".fontctrl"  // inside the font dir
+
+func stateDir(fontDir string) string {
+  return filepath.Join(fontDir, stateDirName)
+}
+
+type TxnState string
+
+const (
+  TxnStaging    = TxnState("staging")     // files are being staged
+  TxnCommitting = TxnState("committing")  // files are being moved into place
+  TxnCommitted  = TxnState("committed")   // only cleanup remains
+)
+
+type TxnOpKind string
+
+const (
+  TxnInstall = TxnOpKind("install")
+  TxnRemove  = TxnOpKind("remove")
+)
+
+type TxnOp struct {
+  Kind    TxnOpKind `json:"kind"`
+  Name    string    `json:"name"`  // path relative to the font dir
+  Receipt *Receipt  `json:"receipt,omitempty"`  // for TxnInstall
+}
+
+type Txn struct {
+  Id    string   `json:"id"`
+  State TxnState `json:"state"`
+  Ops   []*TxnOp `json:"ops"`
+
+  fontDir string
+}
+
+
+// BeginTxn starts a new transaction for fontDir.
+// Fails if there's an unfinished transaction; call RecoverTxn first.
+//
+func BeginTxn(fontDir string) (*Txn, error) {
+  if _, err := os.Stat(journalFile(fontDir)); err == nil {
+    return nil, fmt.Errorf("unfinished transaction in %s", fontDir)
+  }
+  t := &Txn{
+    Id:      fmt.Sprintf("%d", time.Now().UnixNano()),
+    State:   TxnStaging,
+    fontDir: fontDir,
+  }
+  if err := os.MkdirAll(t.stagePath(""), 0755); err != nil {
+    return nil, err
+  }
+  if err := t.writeJournal(); err != nil {
+    os.RemoveAll(t.dir())
+    return nil, err
+  }
+  return t, nil
+}
+
+
+// StagePath returns the path at which the new file name should be written
+// before calling Install(name)
+//
+func (t *Txn) StagePath(name string) string {
+  return t.stagePath(name)
+}
+
+// Install records that the staged file name should be moved into place,
+// and that r should be recorded as its receipt
+//
+func (t *Txn) Install(name string, r *Receipt) {
+  t.Ops = append(t.Ops, &TxnOp{ Kind: TxnInstall, Name: name, Receipt: r })
+}
+
+// Remove records that the file name in the font dir should be removed,
+// along with its receipt
+//
+func (t *Txn) Remove(name string) {
+  t.Ops = append(t.Ops, &TxnOp{ Kind: TxnRemove, Name: name })
+}
+
+
+// Commit applies all operations of t. On failure, changes already made are
+// rolled back. If even that fails, the journal is kept so that the next call
+// to RecoverTxn can finish the transaction.
+//
+func (t *Txn) Commit() error {
+  t.State = TxnCommitting
+  if err := t.writeJournal(); err != nil {
+    t.Abort()
+    return err
+  }
+  if err := t.rollForward(); err != nil {
+    if err2 := t.rollBack(); err2 != nil {
+      L.Printf("error: failed to roll back transaction %s: %v", t.Id, err2)
+      return err
+    }
+    t.cleanup()
+    return err
+  }
+  if err := t.updateReceipts(); err != nil {
+    return err  // journal is kept; RecoverTxn will retry
+  }
+  t.State = TxnCommitted
+  if err := t.writeJournal(); err != nil {
+    return err
+  }
+  return t.cleanup()
+}
+
+
+// Abort discards a transaction that has not been committed
+//
+func (t *Txn) Abort() error {
+  return t.cleanup()
+}
+
+
+// RecoverTxn finishes or undoes a transaction that was interrupted
+// in fontDir, and removes any stray staging directories.
+//
+func RecoverTxn(fontDir string) error {
+  data, err := ioutil.ReadFile(journalFile(fontDir))
+  if err == nil {
+    t := &Txn{ fontDir: fontDir }
+    if err := json.Unmarshal(data, t); err != nil {
+      return fmt.Errorf("corrupt journal %s: %v", journalFile(fontDir), err)
+    }
+    switch t.State {
+      case TxnStaging:
+        L.Printf("rolling back interrupted transaction %s", t.Id)
+      case TxnCommitting:
+        L.Printf("completing interrupted transaction %s", t.Id)
+        if err := t.rollForward(); err != nil {
+          return err
+        }
+        if err := t.updateReceipts(); err != nil {
+          return err
+        }
+    }
+    if err := t.cleanup(); err != nil {
+      return err
+    }
+  } else if !os.IsNotExist(err) {
+    return err
+  }
+
+  // stray staging directories, e.g. from a crash in BeginTxn
+  files, err := ioutil.ReadDir(stateDir(fontDir))
+  if err != nil {
+    if os.IsNotExist(err) {
+      return nil
+    }
+    return err
+  }
+  for _, f := range files {
+    if f.IsDir() && strings.HasPrefix(f.Name(), "txn-") {
+      if err := os.RemoveAll(filepath.Join(stateDir(fontDir), f.Name())); err != nil {
+        return err
+      }
+    }
+  }
+  return nil
+}
+
+
+// rollForward moves staged files into place and old files out of the way.
+// Safe to call repeatedly; operations already carried out are skipped.
+//
+func (t *Txn) rollForward() error {
+  for _, op := range t.Ops {
+    target := filepath.Join(t.fontDir, op.Name)
+    switch op.Kind {
+      case TxnInstall:
+        staged := t.stagePath(op.Name)
+        if !fileExists(staged) {
+          continue  // already installed
+        }
+        if err := moveAside(target, t.backupPath(op.Name)); err != nil {
+          return err
+        }
+        if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+          return err
+        }
+        if err := os.Rename(staged, target); err != nil {
+          return err
+        }
+      case TxnRemove:
+        if err := moveAside(target, t.backupPath(op.Name)); err != nil {
+          return err
+        }
+    }
+  }
+  syncDir(t.fontDir)
+  return nil
+}
+
+
+// rollBack undoes what rollForward did
+//
+func (t *Txn) rollBack() error {
+  for i := len(t.Ops) - 1; i >= 0; i-- {
+    op := t.Ops[i]
+    target := filepath.Join(t.fontDir, op.Name)
+    backup := t.backupPath(op.Name)
+    if op.Kind == TxnInstall {
+      staged := t.stagePath(op.Name)
+      if !fileExists(staged) && fileExists(target) {
+        if err := os.Rename(target, staged); err != nil {
+          return err
+        }
+      }
+    }
+    if fileExists(backup) {
+      if err := os.Rename(backup, target); err != nil {
+        return err
+      }
+    }
+  }
+  syncDir(t.fontDir)
+  return nil
+}
+
+
+// updateReceipts records the outcome of t in the receipt database.
+// Safe to call repeatedly.
+//
+func (t *Txn) updateReceipts() error {
+  db, err := LoadReceipts(t.fontDir)
+  if err != nil {
+    return err
+  }
+  for _, op := range t.Ops {
+    switch op.Kind {
+      case TxnInstall:
+        if op.Receipt != nil {
+          db.Set(op.Name, op.Receipt)
+        }
+      case TxnRemove:
+        db.Delete(op.Name)
+    }
+  }
+  return db.Save()
+}
+
+
+func (t *Txn) cleanup() error {
+  if err := os.RemoveAll(t.dir()); err != nil {
+    return err
+  }
+  err := os.Remove(journalFile(t.fontDir))
+  if err != nil && !os.IsNotExist(err) {
+    return err
+  }
+  return nil
+}
+
+
+func (t *Txn) writeJournal() error {
+  data, err := json.MarshalIndent(t, "", "  ")
+  if err != nil {
+    return err
+  }
+  return writeFileAtomic(journalFile(t.fontDir), data, 0644)
+}
+
+
+func (t *Txn) dir() string {
+  return filepath.Join(stateDir(t.fontDir), "txn-" + t.Id)
+}
+
+func (t *Txn) stagePath(name string) string {
+  return filepath.Join(t.dir(), "new", name)
+}
+
+func (t *Txn) backupPath(name string) string {
+  return filepath.Join(t.dir(), "old", name)
+}
+
+func journalFile(fontDir string) string {
+  return filepath.Join(stateDir(fontDir), "journal.json")
+}
+
+
+// moveAside renames filename to backup, unless filename doesn't exist or
+// backup already exists (i.e. the move has already been done.)
+//
+func moveAside(filename, backup string) error {
+  if !fileExists(filename) || fileExists(backup) {
+    return nil
+  }
+  if err := os.MkdirAll(filepath.Dir(backup), 0755); err != nil {
+    return err
+  }
+  return os.Rename(filename, backup)
+}
+
+
+// writeFileAtomic writes data to a temporary file which is then renamed
+// to filename, so that readers never see a partially-written file.
+//
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+  dir := filepath.Dir(filename)
+  if err := os.MkdirAll(dir, 0755); err != nil {
+    return err
+  }
+  fp, err := ioutil.TempFile(dir, "." + filepath.Base(filename) + ".")
+  if err != nil {
+    return err
+  }
+  tmpname := fp.Name()
+  _, err = fp.Write(data)
+  if err == nil {
+    err = fp.Sync()
+  }
+  if err2 := fp.Close(); err == nil {
+    err = err2
+  }
+  if err == nil {
+    err = os.Chmod(tmpname, perm)
+  }
+  if err == nil {
+    err = os.Rename(tmpname, filename)
+  }
+  if err != nil {
+    os.Remove(tmpname)
+    return err
+  }
+  syncDir(dir)
+  return nil
+}
+
+
+// syncDir flushes directory metadata (e.g. renames) to disk.
+// Not supported on all platforms, so errors are ignored.
+//
+func syncDir(dir string) {
+  if fp, err := os.Open(dir); err == nil {
+    fp.Sync()
+    fp.Close()
+  }
+}
+
+
+func fileExists(filename string) bool {
+  _, err := os.Lstat(filename)
+  return err == nil
+}
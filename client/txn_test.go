@@ -0,0 +1,115 @@
+package main
+
+import (
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+func writeTestFile(t *testing.T, filename, content string) {
+  if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+    t.Fatal(err)
+  }
+  if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+    t.Fatal(err)
+  }
+}
+
+func expectTestFile(t *testing.T, filename, content string) {
+  data, err := ioutil.ReadFile(filename)
+  if content == "" {
+    if err == nil {
+      t.Errorf("%s exists; expected it to be removed", filename)
+    }
+    return
+  }
+  if err != nil {
+    t.Errorf("%v", err)
+  } else if string(data) != content {
+    t.Errorf("%s contains %q ; expected %q", filename, data, content)
+  }
+}
+
+// stageTestTxn stages a transaction that replaces a.otf, adds b.otf and
+// removes c.otf
+func stageTestTxn(t *testing.T, dir string) *Txn {
+  writeTestFile(t, filepath.Join(dir, "a.otf"), "a1")
+  writeTestFile(t, filepath.Join(dir, "c.otf"), "c1")
+  txn, err := BeginTxn(dir)
+  if err != nil {
+    t.Fatal(err)
+  }
+  writeTestFile(t, txn.StagePath("a.otf"), "a2")
+  writeTestFile(t, txn.StagePath("b.otf"), "b2")
+  txn.Install("a.otf", &Receipt{ Font: "a", Hash: "a2" })
+  txn.Install("b.otf", &Receipt{ Font: "b", Hash: "b2" })
+  txn.Remove("c.otf")
+  return txn
+}
+
+func TestTxnCommit(t *testing.T) {
+  dir, _ := ioutil.TempDir("", "fontctrl-test")
+  defer os.RemoveAll(dir)
+
+  txn := stageTestTxn(t, dir)
+  if err := txn.Commit(); err != nil {
+    t.Fatal(err)
+  }
+  expectTestFile(t, filepath.Join(dir, "a.otf"), "a2")
+  expectTestFile(t, filepath.Join(dir, "b.otf"), "b2")
+  expectTestFile(t, filepath.Join(dir, "c.otf"), "")
+  if fileExists(journalFile(dir)) || fileExists(txn.dir()) {
+    t.Errorf("transaction was not cleaned up")
+  }
+
+  receipts, err := LoadReceipts(dir)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if r := receipts.Get("b.otf"); r == nil || r.Font != "b" {
+    t.Errorf("expected receipt for b.otf; got %+v", r)
+  }
+}
+
+func TestTxnRecoverStaging(t *testing.T) {
+  dir, _ := ioutil.TempDir("", "fontctrl-test")
+  defer os.RemoveAll(dir)
+
+  // interrupted before commit -- rolled back
+  stageTestTxn(t, dir)
+  if err := RecoverTxn(dir); err != nil {
+    t.Fatal(err)
+  }
+  expectTestFile(t, filepath.Join(dir, "a.otf"), "a1")
+  expectTestFile(t, filepath.Join(dir, "b.otf"), "")
+  expectTestFile(t, filepath.Join(dir, "c.otf"), "c1")
+  if fileExists(journalFile(dir)) {
+    t.Errorf("journal was not removed")
+  }
+}
+
+func TestTxnRecoverCommitting(t *testing.T) {
+  dir, _ := ioutil.TempDir("", "fontctrl-test")
+  defer os.RemoveAll(dir)
+
+  // interrupted half-way through commit -- rolled forward
+  txn := stageTestTxn(t, dir)
+  txn.State = TxnCommitting
+  if err := txn.writeJournal(); err != nil {
+    t.Fatal(err)
+  }
+  if err := moveAside(filepath.Join(dir, "a.otf"), txn.backupPath("a.otf")); err != nil {
+    t.Fatal(err)
+  }
+
+  if err := RecoverTxn(dir); err != nil {
+    t.Fatal(err)
+  }
+  expectTestFile(t, filepath.Join(dir, "a.otf"), "a2")
+  expectTestFile(t, filepath.Join(dir, "b.otf"), "b2")
+  expectTestFile(t, filepath.Join(dir, "c.otf"), "")
+  if fileExists(journalFile(dir)) || fileExists(txn.dir()) {
+    t.Errorf("transaction was not cleaned up")
+  }
+}
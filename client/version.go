@@ -10,7 +10,8 @@ import (
   "encoding/json"
 )
 
-var versionRegExp *regexp.Regexp
+var versionRegExp, versionBuildRegExp *regexp.Regexp
+var semverRegExp, calverRegExp *regexp.Regexp
 
 func init() {
   versionRegExp = regexp.MustCompile(
@@ -25,6 +26,16 @@ func init() {
     `)?` +
     `\s*`,
   )
+  // build metadata following a pre-release, e.g. "+sha.5114f85"
+  versionBuildRegExp = regexp.MustCompile(`^\+([A-Za-z0-9\-\.]+)`)
+
+  // used by ParseIdentifier to classify versions
+  semverRegExp = regexp.MustCompile(
+    `^(?:0|[1-9]\d*)(?:\.(?:0|[1-9]\d*)){0,2}` +
+    `(?:-[0-9A-Za-z\-\.]+)?(?:\+[0-9A-Za-z\-\.]+)?$`)
+  calverRegExp = regexp.MustCompile(
+    `^(?:19|20)\d\d\.(?:0?[1-9]|1[0-2])(?:\.\d+)*` +  // YYYY.MM[.DD[.MICRO]]
+    `(?:[\-\+][0-9A-Za-z\-\.]+)?$`)
 }
 
 type VersionOperator int
@@ -37,13 +48,29 @@ const (
   Lt      // <
   LtEq    // <=
   Latest  // latest -- includes any Prerel and/or Build
+  Caret   // ^ -- compatible versions; same major (same minor for 0.x)
+  Tilde   // ~ -- same minor if specified, otherwise same major
+  Range   // a - b -- inclusive range
 )
 
-// VersionSpec represents a pattern that matches certain versions
+// VersionConstraint is a single operator applied to a version, e.g. ">=2.1"
 //
-type VersionPattern struct {
-  Version *Version
+type VersionConstraint struct {
   Op      VersionOperator
+  Version *Version  // nil for Any and Latest
+  Upper   *Version  // upper bound; only used by Range
+
+  raw     string    // version as written, for matching opaque versions
+}
+
+// VersionPattern represents a pattern that matches certain versions.
+// A pattern consists of alternatives separated by "||", where each
+// alternative is a space-separated list of constraints that must all match.
+// E.g. ">=2.1 <3 || ^4.2" is [[>=2.1, <3], [^4.2]].
+// The zero value matches the most recent stable release, like "*".
+//
+type VersionPattern struct {
+  Alts [][]VersionConstraint
 }
 
 
@@ -52,42 +79,110 @@ type VersionPattern struct {
 // Returns -1,nil if none matches.
 //
 func (p *VersionPattern) Match(versions []*Version) (int, *Version) {
-  if len(versions) == 0 {
-    return -1, nil
+  for i, v := range versions {
+    if p.Matches(v) {
+      return i, v
+    }
   }
+  return -1, nil
+}
 
-  if p.Op == Latest {
-    return 0, versions[0]
+
+// Filter returns all versions that match p, in the same order as versions
+//
+func (p *VersionPattern) Filter(versions []*Version) []*Version {
+  var matches []*Version
+  for _, v := range versions {
+    if p.Matches(v) {
+      matches = append(matches, v)
+    }
   }
+  return matches
+}
+
 
-  if p.Op == Any && p.Version == nil {
-    for i, v := range versions {
-      if len(v.Prerel) == 0 {
-        return i, v
+// Matches returns true if v satisfies p
+//
+func (p *VersionPattern) Matches(v *Version) bool {
+  if len(p.Alts) == 0 {
+    return len(v.Prerel) == 0  // same as "*"
+  }
+  for _, cs := range p.Alts {
+    ok := true
+    for i := range cs {
+      if !cs[i].Matches(v) {
+        ok = false
+        break
       }
     }
-    return -1, nil
+    if ok {
+      return true
+    }
   }
+  return false
+}
 
-  pv := p.Version  // non-nil when p.Op!=Any
-  for i, v := range versions {
-    switch v.Compare(pv) {
-      case 0: // v == pv
-        if p.Op == Eq || p.Op == LtEq || p.Op == GtEq {
-          return i, v
-        }
-      case -1: // v < pv
-        if p.Op == Lt || p.Op == LtEq {
-          return i, v
-        }
-      case 1: // v > pv
-        if p.Op == Gt || p.Op == GtEq {
-          return i, v
-        }
+
+// Matches returns true if v satisfies c
+//
+func (c *VersionConstraint) Matches(v *Version) bool {
+  if v.Kind == VersionOpaque {
+    // opaque versions have no order by spelling and no pre-releases
+    switch c.Op {
+      case Any, Latest: return true
+      case Eq:          return c.raw == v.Raw
     }
+    return false
   }
+  if c.Version != nil && c.Version.Kind == VersionOpaque {
+    return false  // only matches itself
+  }
+  switch c.Op {
+    case Any:    return len(v.Prerel) == 0
+    case Latest: return true
+    case Eq:     return v.Compare(c.Version) == 0
+    case Gt:     return v.Compare(c.Version) > 0
+    case GtEq:   return v.Compare(c.Version) >= 0
+    case Lt:
+      if len(c.Version.Prerel) == 0 {
+        // "<3" means "before 3" and thus excludes 3.0.0-beta
+        return v.compareNumbers(c.Version) < 0
+      }
+      return v.Compare(c.Version) < 0
+    case LtEq:   return v.Compare(c.Version) <= 0
+    case Range:
+      return v.Compare(c.Version) >= 0 && v.Compare(c.Upper) <= 0
+    case Caret, Tilde:
+      // upper bound is compared on numbers only, so that e.g. 3.0.0-beta
+      // does not match ^2
+      return v.Compare(c.Version) >= 0 && v.compareNumbers(c.upperBound()) < 0
+  }
+  return false
+}
 
-  return -1, nil
+
+// upperBound returns the exclusive upper bound of a Caret or Tilde constraint
+//
+func (c *VersionConstraint) upperBound() *Version {
+  lo := c.Version
+  up := &Version{ Major: -1, Minor: -1, Patch: -1 }
+  if c.Op == Tilde {
+    if lo.Minor > -1 {
+      up.Major, up.Minor = lo.Major, lo.Minor + 1  // ~2.1.3 := <2.2
+    } else {
+      up.Major = lo.Major + 1  // ~2 := <3
+    }
+    return up
+  }
+  switch {
+    case lo.Major > 0 || lo.Minor == -1:
+      up.Major = lo.Major + 1  // ^2.1.3 := <3, ^0 := <1
+    case lo.Minor > 0 || lo.Patch == -1:
+      up.Major, up.Minor = 0, lo.Minor + 1  // ^0.2.3 := <0.3
+    default:
+      up.Major, up.Minor, up.Patch = 0, 0, lo.Patch + 1  // ^0.0.3 := <0.0.4
+  }
+  return up
 }
 
 
@@ -112,94 +207,192 @@ func (p *VersionPattern) UnmarshalYAML(u func(interface{}) error) error {
   return p.Parse(s)
 }
 
-func (p *VersionPattern) MarshalYAML() (interface{}, error) {
+func (p VersionPattern) MarshalYAML() (interface{}, error) {
   return p.String(), nil
 }
 
 
 func (p *VersionPattern) Parse(s string) error {
-  p.Version = nil
+  p.Alts = nil
 
-  i, z := 0, len(s)
-  if z == 0 || (z == 1 && s[i] == '*') {
-    p.Op = Any
+  s = strings.TrimSpace(s)
+  if len(s) == 0 || s == "*" {
+    p.Alts = [][]VersionConstraint{ { VersionConstraint{ Op: Any } } }
     return nil
   }
 
+  for _, alt := range strings.Split(s, "||") {
+    terms := splitVersionPatternTerms(alt)
+    if len(terms) == 0 {
+      return fmt.Errorf("invalid version pattern \"%s\"; empty alternative", s)
+    }
+    var cs []VersionConstraint
+    for i := 0; i < len(terms); i++ {
+      var c VersionConstraint
+      if t := terms[i]; t[0] == '-' || t[len(t) - 1] == '-' {
+        // e.g. "2.0 -2.4", "2.0- 2.4" or a stray "-"
+        return fmt.Errorf(
+          "invalid version pattern \"%s\"; write hyphen ranges as \"a - b\"", s)
+      }
+      if i + 2 < len(terms) && terms[i + 1] == "-" {
+        // hyphen range "a - b"
+        c.Op = Range
+        c.Version = &Version{}
+        c.Upper = &Version{}
+        if err := c.Version.Parse1(terms[i], -1); err != nil {
+          return fmt.Errorf("invalid version pattern \"%s\"; %v", s, err)
+        }
+        if err := c.Upper.Parse1(terms[i + 2], -1); err != nil {
+          return fmt.Errorf("invalid version pattern \"%s\"; %v", s, err)
+        }
+        i += 2
+      } else if err := c.Parse(terms[i]); err != nil {
+        return fmt.Errorf("invalid version pattern \"%s\"; %v", s, err)
+      }
+      cs = append(cs, c)
+    }
+    p.Alts = append(p.Alts, cs)
+  }
+
+  return nil
+}
+
+
+// splitVersionPatternTerms splits s at whitespace, except for whitespace
+// following an operator or surrounding a dot, which is removed.
+// E.g. ">= 2 .3 <3" => [">=2.3", "<3"]
+//
+func splitVersionPatternTerms(s string) []string {
+  var terms []string
+  var buf []byte
+  for i := 0; i < len(s); i++ {
+    c := s[i]
+    if c != ' ' && c != '\t' {
+      buf = append(buf, c)
+      continue
+    }
+    // skip run of whitespace
+    j := i
+    for j + 1 < len(s) && (s[j + 1] == ' ' || s[j + 1] == '\t') {
+      j++
+    }
+    i = j
+    if len(buf) == 0 {
+      continue
+    }
+    last := buf[len(buf) - 1]
+    if strings.IndexByte("<>=^~.", last) != -1 ||
+       (j + 1 < len(s) && s[j + 1] == '.') {
+      continue  // join
+    }
+    terms = append(terms, string(buf))
+    buf = nil
+  }
+  if len(buf) > 0 {
+    terms = append(terms, string(buf))
+  }
+  return terms
+}
+
+
+// Parse parses a single constraint, e.g. ">=2.1", "^2", "latest"
+//
+func (c *VersionConstraint) Parse(s string) error {
+  c.Version = nil
+  c.Upper = nil
+  c.raw = ""
+
+  if len(s) == 0 || s == "*" {
+    c.Op = Any
+    return nil
+  }
   if s == "latest" {
-    p.Op = Latest
+    c.Op = Latest
     return nil
   }
 
-  p.Op = Eq  // default when operator is absent
-
-  parse_op:
-  switch s[i] {
-    case '=': p.Op = Eq
-    // case '*', '-', '+': p.Op = Any
+  i := 0
+  c.Op = Eq  // default when operator is absent
+  switch s[0] {
+    case '=': c.Op = Eq;    i = 1
+    case '^': c.Op = Caret; i = 1
+    case '~': c.Op = Tilde; i = 1
     case '>':
-      i++
-      p.Op = Gt
+      c.Op, i = Gt, 1
       if len(s) > i && s[i] == '=' {
-        p.Op = GtEq
+        c.Op, i = GtEq, 2
       }
     case '<':
-      i++
-      p.Op = Lt
+      c.Op, i = Lt, 1
       if len(s) > i && s[i] == '=' {
-        p.Op = LtEq
-      }
-    case ' ': // skip whitespace
-      i++
-      if i < z {
-        goto parse_op
+        c.Op, i = LtEq, 2
       }
   }
 
-  if i < len(s) {
-    p.Version = &Version{}
-    p.Version.Parse1(s[i:], -1)
-  } else if p.Op != Any {
-    return fmt.Errorf(
-      "invalid version pattern \"%s\"; expecting version number or tag", s)
+  if i == len(s) {
+    return fmt.Errorf("expecting version number or tag after \"%s\"", s)
+  }
+  c.raw = s[i:]
+  c.Version = &Version{}
+  if err := c.Version.Parse1(c.raw, -1); err != nil {
+    if c.Op != Eq {
+      return err
+    }
+    // opaque version identifier, e.g. "r12"
+    if err := c.Version.ParseIdentifier(c.raw); err != nil {
+      return err
+    }
+  }
+  if (c.Op == Caret || c.Op == Tilde) && c.Version.Major < 0 {
+    return fmt.Errorf("\"%s\" requires a major version", s)
   }
-
   return nil
 }
 
 
-func (p *VersionPattern) String() string {
+func (p VersionPattern) String() string {
+  if len(p.Alts) == 0 {
+    return "*"
+  }
+  alts := make([]string, len(p.Alts))
+  for i, cs := range p.Alts {
+    terms := make([]string, len(cs))
+    for j := range cs {
+      terms[j] = cs[j].String()
+    }
+    alts[i] = strings.Join(terms, " ")
+  }
+  return strings.Join(alts, " || ")
+}
+
+
+func (c *VersionConstraint) String() string {
   var ops string
-  switch p.Op {
+  switch c.Op {
     case Any:    return "*"
     case Latest: return "latest"
+    case Range:  return c.Version.String() + " - " + c.Upper.String()
     // case Eq:  ops = ""
     case Gt:     ops = ">"
     case GtEq:   ops = ">="
     case Lt:     ops = "<"
     case LtEq:   ops = "<="
+    case Caret:  ops = "^"
+    case Tilde:  ops = "~"
   }
-  if p.Version != nil {
-    return fmt.Sprintf("%s%s", ops, p.Version.String())
+  if c.Version != nil {
+    return ops + c.Version.String()
   }
   return ops
 }
 
 
-// Match compares a list of version strings and returns the offset to
-// the version that matches v, or -1 if none matches.
-//
-func (p *VersionPattern) Matches(v *Version) bool {
-  // v := Version{}
-
-  // for i, vstr := range vv {
-  //   v
-  // }
-
-  return true
-}
-
-
+type VersionKind int
+const (
+  VersionSemVer = VersionKind(iota)  // SemVer, or anything parsed by Parse
+  VersionCalVer  // date-based, e.g. "2024.05.01"
+  VersionOpaque  // not ordered by its spelling, e.g. "2.003" or "r12"
+)
 
 type Version struct {
   Major  int32  `json:"major"`
@@ -207,6 +400,11 @@ type Version struct {
   Patch  int32  `json:"patch"`
   Prerel string `json:"prerel"`
   Build  string `json:"build"`
+
+  Kind   VersionKind `json:"-"`
+  Raw    string      `json:"-"`  // original spelling; set by ParseIdentifier
+  Order  int         `json:"-"`  // position in the repo's index; higher is
+                                 // more recent; 0 if unknown
 }
 
 // Compare compares two versions a <=> b and returns
@@ -215,6 +413,10 @@ type Version struct {
 //  1 if a > b
 //  0 if a == b
 //
+// Precedence follows SemVer 2.0: pre-release identifiers are compared field
+// by field (numerically or lexically) and build metadata is ignored.
+// Use Equal to also take build metadata into account.
+//
 // Handles wildcard versions too, e.g.
 //   "2" == "2.0.0"
 //   "2" < "1.2.3"
@@ -222,6 +424,171 @@ type Version struct {
 //   "2.*.5" < "2.0.6"
 //
 func (a *Version) Compare(b *Version) int {
+  if a.Kind != VersionSemVer || b.Kind != VersionSemVer {
+    if c, ok := a.compareNonSemVer(b); ok {
+      return c
+    }
+  }
+  return a.compareSemVer(b)
+}
+
+
+// compareSemVer compares a and b by their numbers and pre-release,
+// regardless of their kind
+//
+func (a *Version) compareSemVer(b *Version) int {
+  if c := a.compareNumbers(b); c != 0 {
+    return c
+  }
+
+  // non-empty prerel has lower precedence than empty prerel
+  if len(b.Prerel) == 0 && len(a.Prerel) > 0 {
+    // e.g. "1.2.3-beta > 1.2.3" => -1
+    return -1  // a is lesser than b
+  }
+  if len(a.Prerel) == 0 && len(b.Prerel) > 0 {
+    // e.g. "1.2.3 > 1.2.3-beta" => 1
+    return 1  // a is greater than b
+  }
+  if len(a.Prerel) > 0 && len(b.Prerel) > 0 {
+    return comparePrerel(a.Prerel, b.Prerel)
+  }
+
+  // equivalent
+  return 0
+}
+
+
+// compareNonSemVer handles comparisons where a or b is CalVer or opaque.
+// Returns false if the versions should be compared as SemVer.
+//
+// Opaque versions are ordered by their position in the repo's index, which
+// only works for versions from the same index. Otherwise versions of
+// different kinds are ordered by kind (SemVer < CalVer < opaque). CalVer
+// versions are ordered by date. When positions are unknown, opaque versions
+// with the same spelling are equal and others are compared as SemVer as a
+// best-effort fallback.
+//
+func (a *Version) compareNonSemVer(b *Version) (int, bool) {
+  if (a.Kind == VersionOpaque || b.Kind == VersionOpaque) &&
+     a.Order > 0 && b.Order > 0 {
+    return compareInt(a.Order, b.Order), true
+  }
+  if a.Kind != b.Kind {
+    return compareInt(int(a.Kind), int(b.Kind)), true
+  }
+  switch a.Kind {
+  case VersionOpaque:
+    if a.Raw == b.Raw {
+      return 0, true
+    }
+  case VersionCalVer:
+    // compare all date fields, e.g. "2024.05.01.2" > "2024.5.1"
+    if c := comparePrerel(calverNumbers(a.Raw), calverNumbers(b.Raw)); c != 0 {
+      return c, true
+    }
+  }
+  return 0, false
+}
+
+
+func compareInt(a, b int) int {
+  switch {
+    case a < b: return -1
+    case a > b: return 1
+  }
+  return 0
+}
+
+
+// calverNumbers returns the leading dot-separated numbers of s
+//
+func calverNumbers(s string) string {
+  i := 0
+  for i < len(s) && ((s[i] >= '0' && s[i] <= '9') || s[i] == '.') {
+    i++
+  }
+  return strings.Trim(s[:i], ".")
+}
+
+
+// Equal returns true if a and b have the same precedence and build metadata
+//
+func (a *Version) Equal(b *Version) bool {
+  return a.Compare(b) == 0 && a.Build == b.Build
+}
+
+
+// comparePrerel compares two pre-release strings according to SemVer:
+// dot-separated identifiers are compared from left to right; numeric
+// identifiers numerically, others lexically in ASCII order, and numeric
+// identifiers have lower precedence than alphanumeric ones. If all
+// identifiers are equal, the one with more identifiers is greater.
+//   alpha < alpha.1 < alpha.beta < beta < beta.2 < beta.11 < rc.1
+//
+func comparePrerel(a, b string) int {
+  af := strings.Split(a, ".")
+  bf := strings.Split(b, ".")
+  for i := 0; i < len(af) && i < len(bf); i++ {
+    aIsNum := isNumericIdent(af[i])
+    bIsNum := isNumericIdent(bf[i])
+    switch {
+      case aIsNum && bIsNum:
+        // compare by length first so that arbitrarily large numbers work
+        x := strings.TrimLeft(af[i], "0")
+        y := strings.TrimLeft(bf[i], "0")
+        if len(x) != len(y) {
+          if len(x) < len(y) {
+            return -1
+          }
+          return 1
+        }
+        if x < y {
+          return -1
+        }
+        if y < x {
+          return 1
+        }
+      case aIsNum:
+        return -1
+      case bIsNum:
+        return 1
+      default:
+        if af[i] < bf[i] {
+          return -1
+        }
+        if bf[i] < af[i] {
+          return 1
+        }
+    }
+  }
+  if len(af) < len(bf) {
+    return -1
+  }
+  if len(bf) < len(af) {
+    return 1
+  }
+  return 0
+}
+
+
+func isNumericIdent(s string) bool {
+  if len(s) == 0 {
+    return false
+  }
+  for i := 0; i < len(s); i++ {
+    if s[i] < '0' || s[i] > '9' {
+      return false
+    }
+  }
+  return true
+}
+
+
+// compareNumbers compares only the major, minor and patch numbers of a and b,
+// treating wildcards like Compare does
+//
+func (a *Version) compareNumbers(b *Version) int {
   if a.Major >= 0 && b.Major >= 0 {
     if a.Major < b.Major {
       return -1
@@ -248,35 +615,7 @@ func (a *Version) Compare(b *Version) int {
       return 1
     }
   }
-  
-  // non-empty prerel has lower precedence than empty prerel
-  if len(b.Prerel) == 0 && len(a.Prerel) > 0 {
-    // e.g. "1.2.3-beta > 1.2.3" => -1
-    return -1  // a is lesser than b
-  }
-  if len(a.Prerel) == 0 && len(b.Prerel) > 0 {
-    // e.g. "1.2.3 > 1.2.3-beta" => 1
-    return 1  // a is greater than b
-  }
-  if len(a.Prerel) > 0 && len(b.Prerel) > 0 {
-    if a.Prerel < b.Prerel {
-      return -1
-    }
-    if b.Prerel < a.Prerel {
-      return 1
-    }
-  }
 
-  // string comparison for build
-  if a.Build < b.Build {
-    // e.g. "1.2.3+abc" < "1.2.3+def"
-    return -1
-  }
-  if b.Build < a.Build {
-    return 1
-  }
-
-  // equivalent
   return 0
 }
 
@@ -284,11 +623,19 @@ func (a *Version) Compare(b *Version) int {
 type VersionList []*Version
 func (a VersionList) Len() int           { return len(a) }
 func (a VersionList) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a VersionList) Less(i, j int) bool { return a[i].Compare(a[j]) < 0 }
+func (a VersionList) Less(i, j int) bool {
+  if c := a[i].Compare(a[j]); c != 0 {
+    return c < 0
+  }
+  // same precedence; order by build metadata for a stable result
+  return a[i].Build < a[j].Build
+}
 
 
+// SortVersions sorts v from most recent to least recent
+//
 func SortVersions(v []*Version) {
-  sort.Sort(VersionList(v))
+  sort.Sort(sort.Reverse(VersionList(v)))
 }
 
 
@@ -297,7 +644,7 @@ func (v *Version) UnmarshalJSON(b []byte) error {
   if err := json.Unmarshal(b, &s); err != nil {
     return err
   }
-  return v.Parse(s)
+  return v.ParseIdentifier(s)
 }
 
 
@@ -316,6 +663,10 @@ func ParseVersion(version string) (*Version, error) {
 
 
 func (v *Version) String() string {
+  if len(v.Raw) > 0 {
+    return v.Raw
+  }
+
   var s string
 
   if v.Major > -1 {
@@ -355,16 +706,60 @@ func (v *Version) String() string {
 }
 
 
+// Parse extracts a version from free-form text, such as the version string
+// of a font's name table. E.g. "Version 2.003;abc" => 2.3.0+abc
+//
 func (v *Version) Parse(version string) error {
   return v.Parse1(version, 0)
 }
 
 
+// ParseIdentifier parses a version identifier as published by a repo.
+// Unlike Parse, the entire string is the version. Identifiers that are not
+// SemVer are classified as CalVer or opaque, and String returns the original
+// spelling of the identifier in all cases.
+//
+func (v *Version) ParseIdentifier(version string) error {
+  version = strings.TrimSpace(version)
+  if len(version) == 0 {
+    return errors.New("empty version")
+  }
+
+  kind := VersionOpaque
+  if calverRegExp.MatchString(version) {
+    kind = VersionCalVer
+  } else if semverRegExp.MatchString(version) {
+    kind = VersionSemVer
+  }
+
+  if err := v.Parse(version); err != nil {
+    if kind != VersionOpaque {
+      return err
+    }
+    // no numbers to go by; ordered by position only
+    *v = Version{}
+  }
+  v.Kind = kind
+  v.Raw = version
+  return nil
+}
+
+
 func (v *Version) Parse1(version string, defaultv int32) error {
-  m := versionRegExp.FindStringSubmatch(version)
-  if len(m) == 0 {
+  mi := versionRegExp.FindStringSubmatchIndex(version)
+  if len(mi) == 0 {
     return errors.New("invalid format " + version)
   }
+  v.Kind = VersionSemVer
+  v.Raw = ""
+  v.Order = 0
+
+  m := make([]string, len(mi) / 2)
+  for i := range m {
+    if mi[i * 2] >= 0 {
+      m[i] = version[mi[i * 2]:mi[i * 2 + 1]]
+    }
+  }
   // fmt.Printf("\"%s\" => \"%s\"\n", version, strings.Join(m, "\", \""))
 
   var n uint64
@@ -423,6 +818,10 @@ func (v *Version) Parse1(version string, defaultv int32) error {
     if len(m[4]) == 1 && m[4][0] == '-' {
       // e.g. "1.2.0-xyz"
       v.Prerel = m5
+      // e.g. "1.2.0-xyz+abc"
+      if b := versionBuildRegExp.FindStringSubmatch(version[mi[11]:]); b != nil {
+        v.Build = b[1]
+      }
     } else {
       // e.g. "1.2.0+xyz", "1.2.0;xyz", etc
       v.Build = m5
@@ -1,6 +1,11 @@
 package main
 
-import "testing"
+import (
+  "encoding/json"
+  "strings"
+  "testing"
+  "gopkg.in/yaml.v2"
+)
 
 func TestParseVersion(t *testing.T) {
   successCases := [][]string{
@@ -9,6 +14,7 @@ func TestParseVersion(t *testing.T) {
     []string{"2.3.4+xy123",  "2.3.4+xy123"},
     []string{"2.3.4+1.2.3b", "2.3.4+1.2.3b"},
     []string{"2.3.4-beta",   "2.3.4-beta"},
+    []string{"2.3.4-beta.2+xy.123", "2.3.4-beta.2+xy.123"},
 
     // common
     []string{"2.003", "2.3.0"},
@@ -144,6 +150,26 @@ func TestParseVersionPattern(t *testing.T) {
     []string{"  < 2.3",          "<2.3"},
     []string{"  < 2.3.4",        "<2.3.4"},
 
+    // special
+    []string{"",                 "*"},
+    []string{"latest",           "latest"},
+
+    // caret and tilde
+    []string{"^2",               "^2"},
+    []string{"^2.1",             "^2.1"},
+    []string{"^ 0.2.3",          "^0.2.3"},
+    []string{"~2.1.0",           "~2.1.0"},
+    []string{"~ 2",              "~2"},
+
+    // compound
+    []string{">=2.1 <3",         ">=2.1 <3"},
+    []string{">= 2.1   < 3",     ">=2.1 <3"},
+    []string{">=2 .1 <3 .0",     ">=2.1 <3.0"},
+    []string{"2.0 - 2.4",        "2.0 - 2.4"},
+    []string{"2.0 - 2.4 <2.3",   "2.0 - 2.4 <2.3"},
+    []string{"^1.2 || >=3",      "^1.2 || >=3"},
+    []string{"1||2 ||  3",       "1 || 2 || 3"},
+    []string{"<2 || 3.0 - 3.2 || latest", "<2 || 3.0 - 3.2 || latest"},
   }
   for _, c := range successCases {
     input := c[0]
@@ -275,4 +301,416 @@ func TestCompareVersions(t *testing.T) {
 }
 
 
+func TestParseVersionPatternErrors(t *testing.T) {
+  errorCases := []string{
+    ">=",
+    ">=2 <",
+    "^",
+    "~*",
+    "1 ||",
+    "|| 2",
+    "2.0 -2.4",
+    "2.0- 2.4",
+    "2.0-2.4 -",
+    "- 2.4",
+    "2.0 -",
+    "2.0 - 2.4 - 2.5",
+  }
+  for _, input := range errorCases {
+    var p VersionPattern
+    if err := p.Parse(input); err == nil {
+      t.Errorf("(\"%s\") => \"%s\" ; expected error\n", input, p.String())
+    }
+  }
+}
+
+
+func TestMatchVersionPattern(t *testing.T) {
+  var versions []*Version
+  for _, s := range []string{
+    "0.1.0", "0.1.5", "0.2.0", "1.0.0", "1.2.0", "1.2.7", "1.3.0",
+    "2.0.0", "2.1.0", "2.1.4", "2.4.0", "2.4.9", "2.5.0",
+    "3.0.0-beta", "3.0.0", "3.1.0",
+  } {
+    v, err := ParseVersion(s)
+    if err != nil {
+      t.Fatal(err)
+    }
+    versions = append(versions, v)
+  }
+  SortVersions(versions)
+  if versions[0].String() != "3.1.0" {
+    t.Fatalf("SortVersions => %s first ; expected 3.1.0", versions[0])
+  }
+
+  successCases := [][]string{
+    // pattern, expected match ("" for no match)
+    []string{"*",                 "3.1.0"},
+    []string{"latest",            "3.1.0"},
+    []string{"2",                 "2.5.0"},
+    []string{"2.1",               "2.1.4"},
+    []string{"<3",                "2.5.0"},
+    []string{">=2.1 <2.4",        "2.1.4"},
+    []string{">=2.1 <3",          "2.5.0"},
+    []string{">2 <=2.4",          ""},  // ">2" excludes all of 2.x
+    []string{"^2.1",              "2.5.0"},
+    []string{"^1.2",              "1.3.0"},
+    []string{"^0.1",              "0.1.5"},
+    []string{"^0.1.0",            "0.1.5"},
+    []string{"^0",                "0.2.0"},
+    []string{"^3",                "3.1.0"},
+    []string{"^4",                ""},
+    []string{"~2.1.0",            "2.1.4"},
+    []string{"~2.4",              "2.4.9"},
+    []string{"~2",                "2.5.0"},
+    []string{"2.0 - 2.4",         "2.4.9"},
+    []string{"2.0.0 - 2.4.0",     "2.4.0"},
+    []string{"1.0 - 1.2 || 0.2",  "1.2.7"},
+    []string{"0.2 || 1.0 - 1.2",  "1.2.7"},
+    []string{"5 || 0.1",          "0.1.5"},
+    []string{"5 || 6",            ""},
+    []string{"3.0.0-beta",        "3.0.0-beta"},
+    []string{"<3.0.0",            "2.5.0"},
+    []string{"<3.0.0-rc",         "3.0.0-beta"},
+  }
+  for _, c := range successCases {
+    var p VersionPattern
+    if err := p.Parse(c[0]); err != nil {
+      t.Errorf("Parse(\"%s\") => error %v\n", c[0], err)
+      continue
+    }
+    actual := ""
+    if i, v := p.Match(versions); i != -1 {
+      actual = v.String()
+    }
+    if actual != c[1] {
+      t.Errorf("\"%s\".Match => \"%s\" ; expected \"%s\"\n", c[0], actual, c[1])
+    }
+  }
+}
+
+
+func TestVersionPatternMatches(t *testing.T) {
+  type Sample struct {
+    pattern, version string
+    expected bool
+  }
+  successCases := []Sample{
+    Sample{"",              "2.0.0",       true},
+    Sample{"",              "2.0.0-beta",  false},
+    Sample{"*",             "2.0.0-beta",  false},
+    Sample{"latest",        "2.0.0-beta",  true},
+    Sample{"2",             "2.9.9",       true},
+    Sample{"2",             "3.0.0",       false},
+    Sample{"2.*.4",         "2.7.4",       true},
+    Sample{"2.*.4",         "2.7.5",       false},
+    Sample{"*-beta",        "4.1.0-beta",  true},
+    Sample{">=2.1",         "2.1.0",       true},
+    Sample{">=2.1",         "2.0.9",       false},
+    Sample{">2.1",          "2.1.9",       false},
+    Sample{">2.1",          "2.2.0",       true},
+    Sample{"<=2.1",         "2.1.9",       true},
+    Sample{"<2.1",          "2.1.0-beta",  false},
+    Sample{">=2.1 <3",      "2.9.0",       true},
+    Sample{">=2.1 <3",      "3.0.0",       false},
+    Sample{">=2.1 <3",      "3.0.0-rc",    false},
+    Sample{"^2.1",          "2.1.0-rc",    false},
+    Sample{"^2.1.0-rc",     "2.1.0-rc",    true},
+    Sample{"^0.2.3",        "0.2.9",       true},
+    Sample{"^0.2.3",        "0.3.0",       false},
+    Sample{"^0.0.3",        "0.0.3",       true},
+    Sample{"^0.0.3",        "0.0.4",       false},
+    Sample{"~1.2.3",        "1.2.9",       true},
+    Sample{"~1.2.3",        "1.3.0",       false},
+    Sample{"1.2 - 1.4",     "1.4.7",       true},
+    Sample{"1.2 - 1.4",     "1.5.0",       false},
+    Sample{"1 || 3",        "3.4.5",       true},
+    Sample{"1 || 3",        "2.4.5",       false},
+  }
+  for _, c := range successCases {
+    var p VersionPattern
+    if err := p.Parse(c.pattern); err != nil {
+      t.Errorf("Parse(\"%s\") => error %v\n", c.pattern, err)
+      continue
+    }
+    v, err := ParseVersion(c.version)
+    if err != nil {
+      t.Errorf("ParseVersion(\"%s\") => error %v\n", c.version, err)
+      continue
+    }
+    if actual := p.Matches(v); actual != c.expected {
+      t.Errorf("\"%s\".Matches(\"%s\") => %v ; expected %v\n",
+        c.pattern, c.version, actual, c.expected)
+    }
+  }
+}
+
+
+func TestFilterVersionPattern(t *testing.T) {
+  var versions []*Version
+  for _, s := range []string{"3.0.0", "2.2.0", "2.1.1", "2.1.0-beta", "2.0.0", "1.0.0"} {
+    v, _ := ParseVersion(s)
+    versions = append(versions, v)
+  }
+  successCases := [][]string{
+    []string{"^2",        "2.2.0 2.1.1 2.0.0"},
+    []string{"2.1 - 2.2", "2.2.0 2.1.1"},
+    []string{"latest",    "3.0.0 2.2.0 2.1.1 2.1.0-beta 2.0.0 1.0.0"},
+    []string{"4",         ""},
+  }
+  for _, c := range successCases {
+    var p VersionPattern
+    if err := p.Parse(c[0]); err != nil {
+      t.Fatal(err)
+    }
+    var matches []string
+    for _, v := range p.Filter(versions) {
+      matches = append(matches, v.String())
+    }
+    if actual := strings.Join(matches, " "); actual != c[1] {
+      t.Errorf("\"%s\".Filter => \"%s\" ; expected \"%s\"\n", c[0], actual, c[1])
+    }
+  }
+}
+
+
+func TestMarshalVersionPattern(t *testing.T) {
+  type Doc struct {
+    Version VersionPattern `json:"version" yaml:"version"`
+  }
+  for _, input := range []string{"*", ">=2.1 <3", "^1.2 || 2.0 - 2.4"} {
+    var doc Doc
+    if err := doc.Version.Parse(input); err != nil {
+      t.Fatal(err)
+    }
+
+    data, err := json.Marshal(doc)
+    if err != nil {
+      t.Fatal(err)
+    }
+    var doc2 Doc
+    if err := json.Unmarshal(data, &doc2); err != nil {
+      t.Errorf("json.Unmarshal(%s) => error %v", data, err)
+    } else if doc2.Version.String() != input {
+      t.Errorf("JSON %s => \"%s\" ; expected \"%s\"", data, doc2.Version, input)
+    }
+
+    data, err = yaml.Marshal(doc)
+    if err != nil {
+      t.Fatal(err)
+    }
+    var doc3 Doc
+    if err := yaml.Unmarshal(data, &doc3); err != nil {
+      t.Errorf("yaml.Unmarshal(%s) => error %v", data, err)
+    } else if doc3.Version.String() != input {
+      t.Errorf("YAML %s => \"%s\" ; expected \"%s\"", data, doc3.Version, input)
+    }
+  }
+}
+
+
+func TestSemVerPrecedence(t *testing.T) {
+  // from https://semver.org/spec/v2.0.0.html#spec-item-11
+  // each version has lower precedence than the next
+  ordered := []string{
+    "1.0.0-alpha",
+    "1.0.0-alpha.1",
+    "1.0.0-alpha.beta",
+    "1.0.0-beta",
+    "1.0.0-beta.2",
+    "1.0.0-beta.11",
+    "1.0.0-rc.1",
+    "1.0.0",
+    "2.0.0",
+    "2.1.0",
+    "2.1.1",
+  }
+  for i := 0; i < len(ordered); i++ {
+    a, err := ParseVersion(ordered[i])
+    if err != nil {
+      t.Fatalf("ParseVersion(\"%s\") => error %v", ordered[i], err)
+    }
+    for j := 0; j < len(ordered); j++ {
+      b, _ := ParseVersion(ordered[j])
+      expected := 0
+      if i < j {
+        expected = -1
+      } else if i > j {
+        expected = 1
+      }
+      if actual := a.Compare(b); actual != expected {
+        t.Errorf("\"%s\" <> \"%s\" => %d ; expected %d\n",
+          ordered[i], ordered[j], actual, expected)
+      }
+    }
+  }
+
+  // numeric identifiers are compared as numbers, also when very large
+  successCases := [][]string{
+    []string{"1.0.0-beta.2",  "1.0.0-beta.10"},
+    []string{"1.0.0-9",       "1.0.0-10"},
+    []string{"1.0.0-99999999999999999999", "1.0.0-100000000000000000000"},
+    []string{"1.0.0-1",       "1.0.0-a"},
+    []string{"1.0.0-rc.1",    "1.0.0-rc.1.1"},
+  }
+  for _, c := range successCases {
+    a, _ := ParseVersion(c[0])
+    b, _ := ParseVersion(c[1])
+    if a.Compare(b) != -1 || b.Compare(a) != 1 {
+      t.Errorf("expected \"%s\" < \"%s\"\n", c[0], c[1])
+    }
+  }
+}
+
+
+func TestCompareVersionBuild(t *testing.T) {
+  a, _ := ParseVersion("1.2.3-beta+exp.sha.5114f85")
+  b, _ := ParseVersion("1.2.3-beta+21AF26D3")
+  c, _ := ParseVersion("1.2.3-beta+21AF26D3")
+
+  if a.Compare(b) != 0 {
+    t.Errorf("build metadata should be ignored for precedence")
+  }
+  if a.Equal(b) {
+    t.Errorf("\"%s\".Equal(\"%s\") => true ; expected false", a, b)
+  }
+  if !b.Equal(c) {
+    t.Errorf("\"%s\".Equal(\"%s\") => false ; expected true", b, c)
+  }
+
+  // sorting is stable with respect to build metadata
+  versions := []*Version{ b, a }
+  SortVersions(versions)
+  if versions[0] != a {
+    t.Errorf("SortVersions => %s, %s ; expected %s first", versions[0], versions[1], a)
+  }
+}
+
+
+func TestParseVersionIdentifier(t *testing.T) {
+  type Sample struct {
+    input string
+    kind  VersionKind
+  }
+  successCases := []Sample{
+    Sample{"2",                VersionSemVer},
+    Sample{"2.1",              VersionSemVer},
+    Sample{"1.1.141+2013",     VersionSemVer},
+    Sample{"2.3.4-beta.2",     VersionSemVer},
+    Sample{"2024.05.01",       VersionCalVer},
+    Sample{"2024.5",           VersionCalVer},
+    Sample{"2024.05.01.3",     VersionCalVer},
+    Sample{"2024.05.01-rc",    VersionCalVer},
+    Sample{"2.003",            VersionOpaque},
+    Sample{"v2",               VersionOpaque},
+    Sample{"r12",              VersionOpaque},
+    Sample{"final",            VersionOpaque},
+  }
+  for _, c := range successCases {
+    var v Version
+    if err := v.ParseIdentifier(c.input); err != nil {
+      t.Errorf("(\"%s\") => error %v\n", c.input, err)
+      continue
+    }
+    if v.Kind != c.kind {
+      t.Errorf("(\"%s\") => kind %d ; expected %d\n", c.input, v.Kind, c.kind)
+    }
+    // original spelling is preserved, since it's used to build repo urls
+    if v.String() != c.input {
+      t.Errorf("(\"%s\") => \"%s\" ; expected \"%s\"\n", c.input, v.String(), c.input)
+    }
+  }
+}
+
+
+func TestCompareCalVer(t *testing.T) {
+  ordered := []string{"2023.12.31", "2024.05", "2024.05.01", "2024.05.01.2", "2024.5.2"}
+  for i := 1; i < len(ordered); i++ {
+    var a, b Version
+    a.ParseIdentifier(ordered[i - 1])
+    b.ParseIdentifier(ordered[i])
+    if a.Compare(&b) != -1 || b.Compare(&a) != 1 {
+      t.Errorf("expected \"%s\" < \"%s\"\n", ordered[i - 1], ordered[i])
+    }
+    // positions in a repo's index don't override dates
+    a.Order, b.Order = 2, 1
+    if a.Compare(&b) != -1 || b.Compare(&a) != 1 {
+      t.Errorf("expected \"%s\" < \"%s\" regardless of Order\n",
+        ordered[i - 1], ordered[i])
+    }
+  }
+}
+
+
+func TestCompareMixedKinds(t *testing.T) {
+  // without positions, kinds are ordered SemVer < CalVer < opaque
+  ordered := []string{"1.0", "3000.1", "2023.12.31", "2024.05", "r2", "2.003"}
+  versions := make([]*Version, len(ordered))
+  for i, s := range ordered {
+    versions[i] = &Version{}
+    if err := versions[i].ParseIdentifier(s); err != nil {
+      t.Fatal(err)
+    }
+  }
+  for i, a := range versions {
+    for j, b := range versions {
+      expected := compareInt(i, j)
+      if c := a.Compare(b); c != expected {
+        t.Errorf("\"%s\" <=> \"%s\" = %d ; expected %d", a, b, c, expected)
+      }
+    }
+  }
+}
+
+
+func TestOpaqueVersionOrder(t *testing.T) {
+  // as listed in a repo's index.json, most recent first
+  listed := []string{"2.1", "2.010", "2.003", "r1"}
+  var versions []*Version
+  for i, s := range listed {
+    v := &Version{}
+    if err := v.ParseIdentifier(s); err != nil {
+      t.Fatal(err)
+    }
+    v.Order = len(listed) - i
+    versions = append(versions, v)
+  }
+  versions[0], versions[3] = versions[3], versions[0]
+  SortVersions(versions)
+  for i, v := range versions {
+    if v.String() != listed[i] {
+      t.Errorf("SortVersions => [%d] = %s ; expected %s", i, v, listed[i])
+    }
+  }
+
+  successCases := [][]string{
+    []string{"*",      "2.1"},
+    []string{"2.003",  "2.003"},
+    []string{"r1",     "r1"},
+    []string{"2.3",    ""},  // opaque versions only match by spelling
+    []string{">=2",    "2.1"},
+  }
+  for _, c := range successCases {
+    var p VersionPattern
+    if err := p.Parse(c[0]); err != nil {
+      t.Fatal(err)
+    }
+    actual := ""
+    if i, v := p.Match(versions); i != -1 {
+      actual = v.String()
+    }
+    if actual != c[1] {
+      t.Errorf("\"%s\".Match => \"%s\" ; expected \"%s\"\n", c[0], actual, c[1])
+    }
+  }
+
+  // local font versions are compared by their numbers (see planStyle)
+  var local Version
+  local.Parse("Version 2.003")
+  if local.compareSemVer(versions[2]) != 0 {
+    t.Errorf("\"%s\" <> \"%s\" ; expected equal", local.String(), versions[2])
+  }
+}
+
+
 // TODO: v.Compare(*Version)
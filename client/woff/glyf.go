@@ -0,0 +1,365 @@
+package woff
+
+import (
+  "encoding/binary"
+  "errors"
+  "fmt"
+)
+
+// Reconstruction of the transformed glyf, loca and hmtx tables of WOFF2.
+// See https://www.w3.org/TR/WOFF2/#glyf_table_format
+
+// flags of composite glyph components
+const (
+  argsAreWords     = 0x0001
+  haveScale        = 0x0008
+  moreComponents   = 0x0020
+  haveXYScale      = 0x0040
+  haveTwoByTwo     = 0x0080
+  haveInstructions = 0x0100
+)
+
+// flags of simple glyph points
+const (
+  flagOnCurve        = 0x01
+  flagOverlapSimple  = 0x40
+)
+
+type glyfResult struct {
+  glyf  []byte
+  loca  []byte
+  xMins []int16  // of each glyph, for reconstructing hmtx
+}
+
+
+func reconstructGlyf(data []byte) (*glyfResult, error) {
+  hdr := &buffer{ b: data }
+  hdr.u16()  // reserved
+  optionFlags := hdr.u16()
+  numGlyphs := int(hdr.u16())
+  indexFormat := int(hdr.u16())
+  var sizes [7]int
+  for i := range sizes {
+    sizes[i] = int(hdr.u32())
+  }
+  if hdr.err != nil {
+    return nil, errors.New("woff2: truncated glyf header")
+  }
+  streams := make([]*buffer, len(sizes))
+  for i, size := range sizes {
+    streams[i] = &buffer{ b: hdr.bytes(size) }
+  }
+  if hdr.err != nil {
+    return nil, errors.New("woff2: glyf stream out of bounds")
+  }
+  nContourStream, nPointsStream, flagStream, glyphStream :=
+    streams[0], streams[1], streams[2], streams[3]
+  compositeStream, bboxStream, instructionStream :=
+    streams[4], streams[5], streams[6]
+
+  bboxBitmap := bboxStream.bytes(4 * ((numGlyphs + 31) / 32))
+  var overlapBitmap []byte
+  if optionFlags & 1 != 0 {
+    overlapBitmap = hdr.bytes((numGlyphs + 7) / 8)
+  }
+  if bboxStream.err != nil || hdr.err != nil {
+    return nil, errors.New("woff2: truncated glyf bitmaps")
+  }
+  bit := func(bitmap []byte, i int) bool {
+    return bitmap != nil && bitmap[i >> 3] & (0x80 >> uint(i & 7)) != 0
+  }
+
+  res := &glyfResult{ xMins: make([]int16, numGlyphs) }
+  offsets := make([]int, numGlyphs + 1)
+  var out []byte
+
+  for i := 0; i < numGlyphs; i++ {
+    offsets[i] = len(out)
+    nContours := nContourStream.i16()
+    hasBbox := bit(bboxBitmap, i)
+
+    switch {
+    case nContours == 0:
+      // empty glyph
+      if hasBbox {
+        return nil, fmt.Errorf("woff2: empty glyph %d has a bounding box", i)
+      }
+
+    case nContours < 0:
+      // composite glyph
+      if !hasBbox {
+        return nil, fmt.Errorf("woff2: composite glyph %d has no bounding box", i)
+      }
+      bbox := bboxStream.bytes(8)
+      components, instructions := readComposite(compositeStream)
+      out = appendU16(out, uint16(nContours))
+      out = append(out, bbox...)
+      out = append(out, components...)
+      if instructions {
+        n := glyphStream.uint255()
+        out = appendU16(out, uint16(n))
+        out = append(out, instructionStream.bytes(n)...)
+      }
+      if len(bbox) == 8 {
+        res.xMins[i] = int16(binary.BigEndian.Uint16(bbox))
+      }
+
+    default:
+      // simple glyph
+      endPts := make([]int, nContours)
+      nPoints := 0
+      for c := range endPts {
+        nPoints += nPointsStream.uint255()
+        endPts[c] = nPoints - 1
+      }
+      if nPoints > 0xffff {
+        return nil, fmt.Errorf("woff2: glyph %d has too many points", i)
+      }
+      flags := flagStream.bytes(nPoints)
+      if flagStream.err != nil {
+        break
+      }
+      xs, ys := make([]int16, nPoints), make([]int16, nPoints)
+      var x, y int
+      xMin, yMin, xMax, yMax := 0x7fff, 0x7fff, -0x8000, -0x8000
+      for p := 0; p < nPoints; p++ {
+        dx, dy := decodeTriplet(flags[p], glyphStream)
+        x += dx
+        y += dy
+        xs[p], ys[p] = int16(x), int16(y)
+        xMin, xMax = minInt(xMin, x), maxInt(xMax, x)
+        yMin, yMax = minInt(yMin, y), maxInt(yMax, y)
+      }
+      instructionLength := glyphStream.uint255()
+      instructions := instructionStream.bytes(instructionLength)
+
+      out = appendU16(out, uint16(nContours))
+      if hasBbox {
+        bbox := bboxStream.bytes(8)
+        out = append(out, bbox...)
+        if len(bbox) == 8 {
+          xMin = int(int16(binary.BigEndian.Uint16(bbox)))
+        }
+      } else {
+        for _, v := range []int{ xMin, yMin, xMax, yMax } {
+          out = appendU16(out, uint16(v))
+        }
+      }
+      res.xMins[i] = int16(xMin)
+      for _, e := range endPts {
+        out = appendU16(out, uint16(e))
+      }
+      out = appendU16(out, uint16(instructionLength))
+      out = append(out, instructions...)
+      // flags are written uncompressed, and coordinates as 16-bit deltas
+      for p := 0; p < nPoints; p++ {
+        var f byte
+        if flags[p] & 0x80 == 0 {
+          f = flagOnCurve
+        }
+        if p == 0 && bit(overlapBitmap, i) {
+          f |= flagOverlapSimple
+        }
+        out = append(out, f)
+      }
+      for _, coords := range [][]int16{ xs, ys } {
+        var prev int16
+        for _, v := range coords {
+          out = appendU16(out, uint16(v - prev))
+          prev = v
+        }
+      }
+    }
+
+    for _, s := range streams {
+      if s.err != nil {
+        return nil, fmt.Errorf("woff2: glyph %d: %v", i, s.err)
+      }
+    }
+    for len(out) % 4 != 0 {
+      out = append(out, 0)
+    }
+  }
+  offsets[numGlyphs] = len(out)
+  res.glyf = out
+
+  // loca
+  if indexFormat == 0 {
+    res.loca = make([]byte, 2 * (numGlyphs + 1))
+    for i, offset := range offsets {
+      binary.BigEndian.PutUint16(res.loca[i * 2:], uint16(offset / 2))
+    }
+  } else {
+    res.loca = make([]byte, 4 * (numGlyphs + 1))
+    for i, offset := range offsets {
+      binary.BigEndian.PutUint32(res.loca[i * 4:], uint32(offset))
+    }
+  }
+  return res, nil
+}
+
+
+// readComposite reads the components of a composite glyph and returns them
+// along with whether the glyph has instructions
+//
+func readComposite(r *buffer) ([]byte, bool) {
+  start := r.b
+  size := 0
+  instructions := false
+  for r.err == nil {
+    flags := r.u16()
+    r.u16()  // glyphIndex
+    n := 2
+    if flags & argsAreWords != 0 {
+      n = 4
+    }
+    if flags & haveScale != 0 {
+      n += 2
+    } else if flags & haveXYScale != 0 {
+      n += 4
+    } else if flags & haveTwoByTwo != 0 {
+      n += 8
+    }
+    r.bytes(n)
+    size += 4 + n
+    if flags & haveInstructions != 0 {
+      instructions = true
+    }
+    if flags & moreComponents == 0 {
+      break
+    }
+  }
+  if r.err != nil {
+    return nil, false
+  }
+  return start[:size], instructions
+}
+
+
+// decodeTriplet decodes a point delta of the glyph stream, encoded
+// according to flag (with the on-curve bit masked off)
+//
+func decodeTriplet(flag byte, r *buffer) (dx, dy int) {
+  withSign := func(f byte, v int) int {
+    if f & 1 != 0 {
+      return v
+    }
+    return -v
+  }
+  f := flag & 0x7f
+  switch {
+  case f < 10:
+    dy = withSign(f, (int(f & 14) << 7) + int(r.u8()))
+  case f < 20:
+    dx = withSign(f, (int((f - 10) & 14) << 7) + int(r.u8()))
+  case f < 84:
+    b0 := int(f - 20)
+    b1 := int(r.u8())
+    dx = withSign(f, 1 + (b0 & 0x30) + (b1 >> 4))
+    dy = withSign(f >> 1, 1 + ((b0 & 0x0c) << 2) + (b1 & 0x0f))
+  case f < 120:
+    b0 := int(f - 84)
+    dx = withSign(f, 1 + ((b0 / 12) << 8) + int(r.u8()))
+    dy = withSign(f >> 1, 1 + (((b0 % 12) >> 2) << 8) + int(r.u8()))
+  case f < 124:
+    b := r.bytes(3)
+    if b == nil {
+      return
+    }
+    dx = withSign(f, (int(b[0]) << 4) + (int(b[1]) >> 4))
+    dy = withSign(f >> 1, (int(b[1] & 0x0f) << 8) + int(b[2]))
+  default:
+    b := r.bytes(4)
+    if b == nil {
+      return
+    }
+    dx = withSign(f, (int(b[0]) << 8) + int(b[1]))
+    dy = withSign(f >> 1, (int(b[2]) << 8) + int(b[3]))
+  }
+  return
+}
+
+
+// reconstructHmtx reverses the hmtx transform, which omits left side
+// bearings that are equal to the xMin of the glyph's bounding box
+//
+func reconstructHmtx(data []byte, numGlyphs, numHMetrics int, xMins []int16) ([]byte, error) {
+  if numHMetrics < 1 || numHMetrics > numGlyphs || len(xMins) < numGlyphs {
+    return nil, errors.New("woff2: invalid hmtx metrics count")
+  }
+  r := &buffer{ b: data }
+  flags := r.u8()
+  advances := make([]uint16, numHMetrics)
+  for i := range advances {
+    advances[i] = r.u16()
+  }
+  lsbs := make([]int16, numGlyphs)
+  copy(lsbs, xMins)
+  if flags & 1 == 0 {
+    for i := 0; i < numHMetrics; i++ {
+      lsbs[i] = r.i16()
+    }
+  }
+  if flags & 2 == 0 {
+    for i := numHMetrics; i < numGlyphs; i++ {
+      lsbs[i] = r.i16()
+    }
+  }
+  if r.err != nil {
+    return nil, errors.New("woff2: truncated hmtx table")
+  }
+
+  out := make([]byte, 0, 2 * (numHMetrics + numGlyphs))
+  for i := 0; i < numGlyphs; i++ {
+    if i < numHMetrics {
+      out = appendU16(out, advances[i])
+    }
+    out = appendU16(out, uint16(lsbs[i]))
+  }
+  return out, nil
+}
+
+
+// glyfXMins returns the xMin of each glyph of an untransformed glyf table
+func glyfXMins(glyf, loca []byte, indexFormat, numGlyphs int) []int16 {
+  xMins := make([]int16, numGlyphs)
+  for i := range xMins {
+    var start, end int
+    if indexFormat == 0 {
+      if len(loca) < (i + 2) * 2 {
+        break
+      }
+      start = int(binary.BigEndian.Uint16(loca[i * 2:])) * 2
+      end = int(binary.BigEndian.Uint16(loca[i * 2 + 2:])) * 2
+    } else {
+      if len(loca) < (i + 2) * 4 {
+        break
+      }
+      start = int(binary.BigEndian.Uint32(loca[i * 4:]))
+      end = int(binary.BigEndian.Uint32(loca[i * 4 + 4:]))
+    }
+    if end - start >= 10 && start + 4 <= len(glyf) {
+      xMins[i] = int16(binary.BigEndian.Uint16(glyf[start + 2:]))
+    }
+  }
+  return xMins
+}
+
+
+func appendU16(b []byte, v uint16) []byte {
+  return append(b, byte(v >> 8), byte(v))
+}
+
+func minInt(a, b int) int {
+  if a < b {
+    return a
+  }
+  return b
+}
+
+func maxInt(a, b int) int {
+  if a > b {
+    return a
+  }
+  return b
+}
@@ -0,0 +1,282 @@
+// Package woff decodes WOFF and WOFF2 web fonts into plain sfnt
+// (TrueType/OpenType) fonts.
+//
+// See https://www.w3.org/TR/WOFF/ and https://www.w3.org/TR/WOFF2/
+//
+package woff
+
+import (
+  "bytes"
+  "compress/zlib"
+  "encoding/binary"
+  "errors"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "sort"
+)
+
+const (
+  signatureWOFF  = 0x774F4646  // "wOFF"
+  signatureWOFF2 = 0x774F4632  // "wOF2"
+  flavorTTC      = 0x74746366  // "ttcf"
+)
+
+// ErrFormat is returned when data is not a WOFF or WOFF2 file
+var ErrFormat = errors.New("woff: not a WOFF or WOFF2 file")
+
+
+// IsWOFF returns true if header, the first 4 or more bytes of a file,
+// starts with the signature of WOFF or WOFF2
+//
+func IsWOFF(header []byte) bool {
+  if len(header) < 4 {
+    return false
+  }
+  sig := binary.BigEndian.Uint32(header)
+  return sig == signatureWOFF || sig == signatureWOFF2
+}
+
+
+// Decode decodes a WOFF or WOFF2 file into an sfnt font, or a font
+// collection if data is a WOFF2 collection
+//
+func Decode(data []byte) ([]byte, error) {
+  if len(data) >= 4 {
+    switch binary.BigEndian.Uint32(data) {
+      case signatureWOFF:  return DecodeWOFF(data)
+      case signatureWOFF2: return DecodeWOFF2(data)
+    }
+  }
+  return nil, ErrFormat
+}
+
+
+// DecodeWOFF decodes a WOFF (version 1) file into an sfnt font
+//
+func DecodeWOFF(data []byte) ([]byte, error) {
+  if len(data) < 44 || binary.BigEndian.Uint32(data) != signatureWOFF {
+    return nil, ErrFormat
+  }
+  flavor := binary.BigEndian.Uint32(data[4:])
+  numTables := int(binary.BigEndian.Uint16(data[12:]))
+  totalSfntSize := int(binary.BigEndian.Uint32(data[16:]))
+  if len(data) < 44 + numTables * 20 {
+    return nil, errors.New("woff: truncated table directory")
+  }
+
+  // check the size of the decoded tables before allocating any memory
+  total := 0
+  for i := 0; i < numTables; i++ {
+    total += int(binary.BigEndian.Uint32(data[44 + i * 20 + 12:]))
+    if total > totalSfntSize || total > maxDecodedSize {
+      return nil, errors.New("woff: tables too large")
+    }
+  }
+
+  tables := make([]*table, numTables)
+  for i := range tables {
+    e := data[44 + i * 20:]
+    offset := int(binary.BigEndian.Uint32(e[4:]))
+    compLength := int(binary.BigEndian.Uint32(e[8:]))
+    origLength := int(binary.BigEndian.Uint32(e[12:]))
+    t := &table{ tag: string(e[:4]) }
+    if offset < 0 || compLength < 0 || offset + compLength > len(data) {
+      return nil, fmt.Errorf("woff: %s table out of bounds", t.tag)
+    }
+    b := data[offset:offset + compLength]
+    switch {
+      case compLength == origLength:
+        t.data = b
+      case compLength < origLength:
+        zr, err := zlib.NewReader(bytes.NewReader(b))
+        if err != nil {
+          return nil, fmt.Errorf("woff: %s table: %v", t.tag, err)
+        }
+        t.data, err = readDecompressed(zr, origLength)
+        zr.Close()
+        if err != nil {
+          return nil, fmt.Errorf("woff: %s table: %v", t.tag, err)
+        }
+      default:
+        return nil, fmt.Errorf("woff: %s table larger when compressed", t.tag)
+    }
+    tables[i] = t
+  }
+
+  return writeSfnt(flavor, tables), nil
+}
+
+
+// readDecompressed reads the n bytes that r decompresses to. n comes from
+// the header of a file and isn't trusted: memory is allocated as data is
+// decompressed, and it is an error if r yields more or less than n bytes.
+//
+func readDecompressed(r io.Reader, n int) ([]byte, error) {
+  b, err := ioutil.ReadAll(io.LimitReader(r, int64(n) + 1))
+  if err != nil {
+    return nil, err
+  }
+  if len(b) != n {
+    return nil, fmt.Errorf("decompressed to %d bytes; expected %d", len(b), n)
+  }
+  return b, nil
+}
+
+
+// table is a decoded font table
+type table struct {
+  tag  string
+  data []byte
+}
+
+
+// writeSfnt writes a single font with tables, in tag order as required
+func writeSfnt(flavor uint32, tables []*table) []byte {
+  tables = sortedTables(tables)
+  headerSize := 12 + 16 * len(tables)
+  offsets, size := layoutTables(headerSize, tables)
+
+  b := make([]byte, size)
+  writeOffsetTable(b, flavor, tables, offsets)
+  for i, t := range tables {
+    copy(b[offsets[i]:], t.data)
+  }
+
+  // update head.checksumAdjustment
+  for i, t := range tables {
+    if t.tag == "head" && len(t.data) >= 12 {
+      adj := b[offsets[i] + 8:offsets[i] + 12]
+      binary.BigEndian.PutUint32(adj, 0)
+      binary.BigEndian.PutUint32(adj, 0xB1B0AFBA - checksum(b))
+    }
+  }
+  return b
+}
+
+
+// writeCollection writes a font collection (TTC) of fonts, each of which is
+// a list of indices into tables. Tables may be shared between fonts.
+//
+func writeCollection(flavors []uint32, fonts [][]int, tables []*table) []byte {
+  headerSize := 12 + 4 * len(fonts)
+  fontOffsets := make([]int, len(fonts))
+  for i, indices := range fonts {
+    fontOffsets[i] = headerSize
+    headerSize += 12 + 16 * len(indices)
+  }
+  offsets, size := layoutTables(headerSize, tables)
+
+  b := make([]byte, size)
+  copy(b, "ttcf")
+  binary.BigEndian.PutUint32(b[4:], 0x00010000)  // version 1.0
+  binary.BigEndian.PutUint32(b[8:], uint32(len(fonts)))
+  for i, indices := range fonts {
+    binary.BigEndian.PutUint32(b[12 + i * 4:], uint32(fontOffsets[i]))
+    ftables := make([]*table, len(indices))
+    foffsets := make([]int, len(indices))
+    for j, ti := range indices {
+      ftables[j] = tables[ti]
+      foffsets[j] = offsets[ti]
+    }
+    sort.Sort(byTag{ ftables, foffsets })
+    writeOffsetTable(b[fontOffsets[i]:], flavors[i], ftables, foffsets)
+  }
+  for i, t := range tables {
+    copy(b[offsets[i]:], t.data)
+  }
+  return b
+}
+
+
+// layoutTables returns the 4-byte aligned offsets of tables following a
+// header of headerSize bytes, and the total size
+//
+func layoutTables(headerSize int, tables []*table) ([]int, int) {
+  offsets := make([]int, len(tables))
+  offset := headerSize
+  for i, t := range tables {
+    offset = align4(offset)
+    offsets[i] = offset
+    offset += len(t.data)
+  }
+  return offsets, align4(offset)
+}
+
+
+// writeOffsetTable writes the offset table and table records of a font
+func writeOffsetTable(b []byte, flavor uint32, tables []*table, offsets []int) {
+  n := len(tables)
+  entrySelector := 0
+  for 2 << uint(entrySelector) <= n {
+    entrySelector++
+  }
+  searchRange := (1 << uint(entrySelector)) * 16
+  binary.BigEndian.PutUint32(b[0:], flavor)
+  binary.BigEndian.PutUint16(b[4:], uint16(n))
+  binary.BigEndian.PutUint16(b[6:], uint16(searchRange))
+  binary.BigEndian.PutUint16(b[8:], uint16(entrySelector))
+  binary.BigEndian.PutUint16(b[10:], uint16(n * 16 - searchRange))
+  for i, t := range tables {
+    r := b[12 + i * 16:]
+    copy(r, t.tag)
+    binary.BigEndian.PutUint32(r[4:], tableChecksum(t))
+    binary.BigEndian.PutUint32(r[8:], uint32(offsets[i]))
+    binary.BigEndian.PutUint32(r[12:], uint32(len(t.data)))
+  }
+}
+
+
+// tableChecksum computes the checksum of t. The checksum of the head table
+// is computed as if its checksumAdjustment was zero.
+//
+func tableChecksum(t *table) uint32 {
+  sum := checksum(t.data)
+  if t.tag == "head" && len(t.data) >= 12 {
+    sum -= binary.BigEndian.Uint32(t.data[8:])
+  }
+  return sum
+}
+
+
+// checksum computes the sfnt checksum of b, which is padded with zeros
+func checksum(b []byte) uint32 {
+  var sum uint32
+  for len(b) >= 4 {
+    sum += binary.BigEndian.Uint32(b)
+    b = b[4:]
+  }
+  if len(b) > 0 {
+    var pad [4]byte
+    copy(pad[:], b)
+    sum += binary.BigEndian.Uint32(pad[:])
+  }
+  return sum
+}
+
+
+func align4(n int) int {
+  return (n + 3) &^ 3
+}
+
+
+func sortedTables(tables []*table) []*table {
+  sorted := make([]*table, len(tables))
+  copy(sorted, tables)
+  sort.Slice(sorted, func(i, j int) bool { return sorted[i].tag < sorted[j].tag })
+  return sorted
+}
+
+
+// byTag sorts tables and their offsets by tag
+type byTag struct {
+  tables  []*table
+  offsets []int
+}
+
+func (s byTag) Len() int           { return len(s.tables) }
+func (s byTag) Less(i, j int) bool { return s.tables[i].tag < s.tables[j].tag }
+func (s byTag) Swap(i, j int) {
+  s.tables[i], s.tables[j] = s.tables[j], s.tables[i]
+  s.offsets[i], s.offsets[j] = s.offsets[j], s.offsets[i]
+}
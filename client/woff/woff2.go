@@ -0,0 +1,267 @@
+package woff
+
+import (
+  "bytes"
+  "encoding/binary"
+  "errors"
+  "fmt"
+
+  "github.com/andybalholm/brotli"
+)
+
+// maxDecodedSize limits the total size of decoded tables, to protect
+// against malformed files
+const maxDecodedSize = 1 << 30
+
+// knownTags are the tags of tables that WOFF2 encodes as an index
+var knownTags = [63]string{
+  "cmap", "head", "hhea", "hmtx", "maxp", "name", "OS/2", "post",
+  "cvt ", "fpgm", "glyf", "loca", "prep", "CFF ", "VORG", "EBDT",
+  "EBLC", "gasp", "hdmx", "kern", "LTSH", "PCLT", "VDMX", "vhea",
+  "vmtx", "BASE", "GDEF", "GPOS", "GSUB", "EBSC", "JSTF", "MATH",
+  "CBDT", "CBLC", "COLR", "CPAL", "SVG ", "sbix", "acnt", "avar",
+  "bdat", "bloc", "bsln", "cvar", "fdsc", "feat", "fmtx", "fvar",
+  "gvar", "hsty", "just", "lcar", "mort", "morx", "opbd", "prop",
+  "trak", "Zapf", "Silf", "Glat", "Gloc", "Feat", "Sill",
+}
+
+// woff2Table is an entry of the WOFF2 table directory
+type woff2Table struct {
+  table
+  transform   int   // transformation version
+  transformed bool  // true if data needs to be reconstructed
+  origLength  int
+  length      int   // length of data in the decompressed stream
+}
+
+
+// DecodeWOFF2 decodes a WOFF2 file into an sfnt font, or a font collection
+// if data contains a collection. Transformed glyf, loca and hmtx tables are
+// reconstructed.
+//
+func DecodeWOFF2(data []byte) ([]byte, error) {
+  if len(data) < 48 || binary.BigEndian.Uint32(data) != signatureWOFF2 {
+    return nil, ErrFormat
+  }
+  flavor := binary.BigEndian.Uint32(data[4:])
+  numTables := int(binary.BigEndian.Uint16(data[12:]))
+  totalCompressedSize := int(binary.BigEndian.Uint32(data[20:]))
+
+  r := &buffer{ b: data[48:] }
+  tables := make([]*woff2Table, numTables)
+  total := 0
+  for i := range tables {
+    flags := r.u8()
+    t := &woff2Table{ transform: int(flags >> 6) }
+    if flags & 0x3f == 0x3f {
+      t.tag = string(r.bytes(4))
+    } else {
+      t.tag = knownTags[flags & 0x3f]
+    }
+    t.origLength = r.base128()
+    if t.tag == "glyf" || t.tag == "loca" {
+      t.transformed = t.transform != 3  // 3 means "not transformed"
+    } else {
+      t.transformed = t.transform != 0
+    }
+    t.length = t.origLength
+    if t.transformed {
+      t.length = r.base128()
+    }
+    if r.err != nil {
+      return nil, r.err
+    }
+    total += t.length
+    if total > maxDecodedSize {
+      return nil, errors.New("woff2: tables too large")
+    }
+    tables[i] = t
+  }
+
+  // collection directory
+  var flavors []uint32
+  var fonts [][]int
+  if flavor == flavorTTC {
+    r.u32()  // version
+    numFonts := r.uint255()
+    for i := 0; i < numFonts && r.err == nil; i++ {
+      n := r.uint255()
+      flavors = append(flavors, r.u32())
+      indices := make([]int, n)
+      for j := range indices {
+        indices[j] = r.uint255()
+        if indices[j] >= numTables {
+          return nil, errors.New("woff2: invalid table index in collection")
+        }
+      }
+      fonts = append(fonts, indices)
+    }
+  } else {
+    indices := make([]int, numTables)
+    for i := range indices {
+      indices[i] = i
+    }
+    flavors = []uint32{ flavor }
+    fonts = [][]int{ indices }
+  }
+  if r.err != nil {
+    return nil, r.err
+  }
+
+  // decompress table data
+  compressed := r.bytes(totalCompressedSize)
+  if r.err != nil {
+    return nil, r.err
+  }
+  stream, err := readDecompressed(brotli.NewReader(bytes.NewReader(compressed)), total)
+  if err != nil {
+    return nil, fmt.Errorf("woff2: %v", err)
+  }
+  for _, t := range tables {
+    t.data = stream[:t.length:t.length]
+    stream = stream[t.length:]
+  }
+
+  for _, indices := range fonts {
+    if err := reconstructFont(tables, indices); err != nil {
+      return nil, err
+    }
+  }
+
+  plain := make([]*table, len(tables))
+  for i, t := range tables {
+    plain[i] = &t.table
+  }
+  if flavor == flavorTTC {
+    return writeCollection(flavors, fonts, plain), nil
+  }
+  return writeSfnt(flavor, plain), nil
+}
+
+
+// reconstructFont reverses the transforms of the tables of a font.
+// Tables shared with a previously reconstructed font are left as is.
+//
+func reconstructFont(tables []*woff2Table, indices []int) error {
+  find := func(tag string) *woff2Table {
+    for _, i := range indices {
+      if tables[i].tag == tag {
+        return tables[i]
+      }
+    }
+    return nil
+  }
+
+  glyf, loca := find("glyf"), find("loca")
+  if (glyf == nil) != (loca == nil) {
+    return errors.New("woff2: glyf and loca tables must both be present")
+  }
+  var xMins []int16
+  if glyf != nil && glyf.transformed {
+    if !loca.transformed {
+      return errors.New("woff2: glyf is transformed but loca is not")
+    }
+    if glyf.transform != 0 {
+      return fmt.Errorf("woff2: unknown glyf transform %d", glyf.transform)
+    }
+    g, err := reconstructGlyf(glyf.data)
+    if err != nil {
+      return err
+    }
+    glyf.data, loca.data, xMins = g.glyf, g.loca, g.xMins
+    if len(loca.data) != loca.origLength {
+      return errors.New("woff2: reconstructed loca has wrong length")
+    }
+    glyf.transformed, loca.transformed = false, false
+  }
+
+  for _, i := range indices {
+    t := tables[i]
+    if !t.transformed {
+      continue
+    }
+    if t.tag != "hmtx" || t.transform != 1 {
+      return fmt.Errorf("woff2: unknown transform %d of %s table", t.transform, t.tag)
+    }
+    hhea, maxp, head := find("hhea"), find("maxp"), find("head")
+    if hhea == nil || maxp == nil || head == nil || glyf == nil ||
+       len(hhea.data) < 36 || len(maxp.data) < 6 || len(head.data) < 54 {
+      return errors.New("woff2: transformed hmtx without glyf, head, hhea or maxp")
+    }
+    numGlyphs := int(binary.BigEndian.Uint16(maxp.data[4:]))
+    numHMetrics := int(binary.BigEndian.Uint16(hhea.data[34:]))
+    if xMins == nil {
+      indexFormat := int(binary.BigEndian.Uint16(head.data[50:]))
+      xMins = glyfXMins(glyf.data, loca.data, indexFormat, numGlyphs)
+    }
+    data, err := reconstructHmtx(t.data, numGlyphs, numHMetrics, xMins)
+    if err != nil {
+      return err
+    }
+    t.data = data
+    t.transformed = false
+  }
+  return nil
+}
+
+
+// buffer reads big-endian values from b. The first read beyond the end of
+// b sets err, after which all reads return zero values.
+//
+type buffer struct {
+  b   []byte
+  err error
+}
+
+var errUnexpectedEnd = errors.New("woff2: unexpected end of data")
+
+func (r *buffer) bytes(n int) []byte {
+  if r.err == nil && (n < 0 || n > len(r.b)) {
+    r.err = errUnexpectedEnd
+  }
+  if r.err != nil {
+    if n > 0 && n <= 4 {
+      return make([]byte, n)  // zeros for u8, u16 and u32
+    }
+    return nil
+  }
+  b := r.b[:n:n]
+  r.b = r.b[n:]
+  return b
+}
+
+func (r *buffer) u8() uint8   { return r.bytes(1)[0] }
+func (r *buffer) u16() uint16 { return binary.BigEndian.Uint16(r.bytes(2)) }
+func (r *buffer) i16() int16  { return int16(r.u16()) }
+func (r *buffer) u32() uint32 { return binary.BigEndian.Uint32(r.bytes(4)) }
+
+// uint255 reads a 255UInt16 value
+func (r *buffer) uint255() int {
+  switch code := r.u8(); code {
+    case 253: return int(r.u16())
+    case 254: return int(r.u8()) + 253 * 2
+    case 255: return int(r.u8()) + 253
+    default:  return int(code)
+  }
+}
+
+// base128 reads a UIntBase128 value
+func (r *buffer) base128() int {
+  var v uint32
+  for i := 0; i < 5; i++ {
+    c := r.u8()
+    if r.err != nil {
+      return 0
+    }
+    if (i == 0 && c == 0x80) || v & 0xfe000000 != 0 {
+      r.err = errors.New("woff2: invalid UIntBase128 value")
+      return 0
+    }
+    v = v << 7 | uint32(c & 0x7f)
+    if c & 0x80 == 0 {
+      return int(v)
+    }
+  }
+  r.err = errors.New("woff2: invalid UIntBase128 value")
+  return 0
+}
@@ -0,0 +1,303 @@
+package woff
+
+import (
+  "bytes"
+  "compress/zlib"
+  "encoding/binary"
+  "runtime"
+  "testing"
+
+  "github.com/andybalholm/brotli"
+)
+
+// be returns the big-endian encoding of values, which must be of fixed size
+func be(values ...interface{}) []byte {
+  var b bytes.Buffer
+  for _, v := range values {
+    binary.Write(&b, binary.BigEndian, v)
+  }
+  return b.Bytes()
+}
+
+func testTables() []*table {
+  head := make([]byte, 54)
+  copy(head, be(uint16(1), uint16(0), uint32(0x00018000)))  // fontRevision 1.5
+  return []*table{
+    { tag: "name", data: bytes.Repeat([]byte("name table "), 20) },
+    { tag: "head", data: head },
+    { tag: "post", data: be(uint32(0x00030000)) },
+  }
+}
+
+// makeWOFF encodes tables as WOFF, compressing tables which get smaller
+func makeWOFF(flavor uint32, tables []*table) []byte {
+  var dir, data bytes.Buffer
+  offset := 44 + 20 * len(tables)
+  for _, t := range tables {
+    var z bytes.Buffer
+    zw := zlib.NewWriter(&z)
+    zw.Write(t.data)
+    zw.Close()
+    b := t.data
+    if z.Len() < len(b) {
+      b = z.Bytes()
+    }
+    dir.WriteString(t.tag)
+    dir.Write(be(uint32(offset + data.Len()), uint32(len(b)), uint32(len(t.data)),
+      checksum(t.data)))
+    data.Write(b)
+    for data.Len() % 4 != 0 {
+      data.WriteByte(0)
+    }
+  }
+  sfntSize := 12 + 16 * len(tables)
+  for _, t := range tables {
+    sfntSize += align4(len(t.data))
+  }
+  hdr := be(uint32(signatureWOFF), flavor, uint32(offset + data.Len()),
+    uint16(len(tables)), uint16(0), uint32(sfntSize), uint16(1), uint16(0),
+    uint32(0), uint32(0), uint32(0), uint32(0), uint32(0))
+  return append(append(hdr, dir.Bytes()...), data.Bytes()...)
+}
+
+type woff2TestTable struct {
+  tag       string
+  transform int
+  origLen   int
+  data      []byte  // transformed data, if transformed
+}
+
+// makeWOFF2 encodes tables as WOFF2. collection is appended to the table
+// directory as-is.
+//
+func makeWOFF2(flavor uint32, tables []woff2TestTable, collection []byte) []byte {
+  var dir, stream bytes.Buffer
+  for _, t := range tables {
+    index := 63
+    for i, tag := range knownTags {
+      if tag == t.tag {
+        index = i
+      }
+    }
+    dir.WriteByte(byte(index | t.transform << 6))
+    if index == 63 {
+      dir.WriteString(t.tag)
+    }
+    dir.Write(base128(t.origLen))
+    transformed := t.transform != 0
+    if t.tag == "glyf" || t.tag == "loca" {
+      transformed = t.transform != 3
+    }
+    if transformed {
+      dir.Write(base128(len(t.data)))
+    }
+    stream.Write(t.data)
+  }
+  dir.Write(collection)
+
+  var compressed bytes.Buffer
+  bw := brotli.NewWriter(&compressed)
+  bw.Write(stream.Bytes())
+  bw.Close()
+
+  hdr := be(uint32(signatureWOFF2), flavor, uint32(0), uint16(len(tables)),
+    uint16(0), uint32(0), uint32(compressed.Len()), uint16(1), uint16(0),
+    uint32(0), uint32(0), uint32(0), uint32(0), uint32(0))
+  return append(append(hdr, dir.Bytes()...), compressed.Bytes()...)
+}
+
+func base128(v int) []byte {
+  b := []byte{ byte(v & 0x7f) }
+  for v >>= 7; v > 0; v >>= 7 {
+    b = append([]byte{ byte(v & 0x7f | 0x80) }, b...)
+  }
+  return b
+}
+
+
+func TestDecodeWOFF(t *testing.T) {
+  tables := testTables()
+  expected := writeSfnt(0x00010000, tables)
+  data, err := Decode(makeWOFF(0x00010000, tables))
+  if err != nil {
+    t.Fatal(err)
+  }
+  if !bytes.Equal(data, expected) {
+    t.Errorf("decoded font differs from original")
+  }
+  if sum := checksum(data); sum != 0xB1B0AFBA {
+    t.Errorf("font checksum = %08x ; expected b1b0afba", sum)
+  }
+}
+
+
+func TestDecodeWOFF2(t *testing.T) {
+  tables := testTables()
+  var tables2 []woff2TestTable
+  for _, t := range tables {
+    tables2 = append(tables2, woff2TestTable{ t.tag, 0, len(t.data), t.data })
+  }
+  expected := writeSfnt(0x4F54544F, tables)
+  data, err := Decode(makeWOFF2(0x4F54544F, tables2, nil))
+  if err != nil {
+    t.Fatal(err)
+  }
+  if !bytes.Equal(data, expected) {
+    t.Errorf("decoded font differs from original")
+  }
+}
+
+
+func TestDecodeWOFF2Collection(t *testing.T) {
+  tables := testTables()
+  var tables2 []woff2TestTable
+  for _, t := range tables {
+    tables2 = append(tables2, woff2TestTable{ t.tag, 0, len(t.data), t.data })
+  }
+  // two fonts sharing the head table
+  collection := be(uint32(0x00010000), uint8(2),
+    uint8(2), uint32(0x00010000), uint8(0), uint8(1),
+    uint8(2), uint32(0x00010000), uint8(1), uint8(2))
+  expected := writeCollection(
+    []uint32{ 0x00010000, 0x00010000 }, [][]int{ { 0, 1 }, { 1, 2 } }, tables)
+  data, err := Decode(makeWOFF2(flavorTTC, tables2, collection))
+  if err != nil {
+    t.Fatal(err)
+  }
+  if !bytes.Equal(data, expected) {
+    t.Errorf("decoded collection differs from original")
+  }
+}
+
+
+func TestDecodeWOFF2TransformedGlyf(t *testing.T) {
+  // glyph 0 is empty, glyph 1 is a simple glyph with one contour of four
+  // points, glyph 2 is a composite of glyph 1
+  glyfTransformed := bytes.Join([][]byte{
+    be(uint16(0), uint16(0), uint16(3), uint16(0)),  // numGlyphs=3, short loca
+    be(uint32(6), uint32(1), uint32(4), uint32(14), uint32(6), uint32(12), uint32(0)),
+    be(int16(0), int16(1), int16(-1)),  // nContours
+    be(uint8(4)),  // nPoints
+    be(uint8(127), uint8(127), uint8(0x80 | 126), uint8(1)),  // flags
+    be(uint16(10), uint16(0), uint16(100), uint16(0), uint16(50), uint16(200),
+       uint8(5),  // triplets
+       uint8(0)),  // instruction length of glyph 1
+    be(uint16(0), uint16(1), int8(5), int8(5)),  // component
+    be(uint32(0x20000000), int16(-5), int16(-1), int16(115), int16(206)),  // bbox
+  }, nil)
+  hmtxTransformed := be(uint8(3), uint16(500), uint16(600), uint16(700))
+  head := make([]byte, 54)  // indexToLocFormat=0
+  hhea := make([]byte, 36)
+  binary.BigEndian.PutUint16(hhea[34:], 3)  // numberOfHMetrics
+  maxp := be(uint32(0x00005000), uint16(3))  // numGlyphs
+
+  expectedGlyf := bytes.Join([][]byte{
+    // glyph 1
+    be(int16(1), int16(10), int16(0), int16(110), int16(205)),
+    be(uint16(3), uint16(0)),  // endPtsOfContours, instructionLength
+    be(uint8(1), uint8(1), uint8(0), uint8(1)),  // flags
+    be(int16(10), int16(100), int16(-50), int16(0)),  // x
+    be(int16(0), int16(0), int16(200), int16(5)),  // y
+    be(uint16(0)),  // padding
+    // glyph 2
+    be(int16(-1), int16(-5), int16(-1), int16(115), int16(206)),
+    be(uint16(0), uint16(1), int8(5), int8(5)),
+  }, nil)
+  expectedLoca := be(uint16(0), uint16(0), uint16(36 / 2), uint16(52 / 2))
+  expectedHmtx := be(uint16(500), int16(0), uint16(600), int16(10),
+    uint16(700), int16(-5))
+
+  data, err := Decode(makeWOFF2(0x00010000, []woff2TestTable{
+    { "head", 0, len(head), head },
+    { "hhea", 0, len(hhea), hhea },
+    { "maxp", 0, len(maxp), maxp },
+    { "glyf", 0, len(expectedGlyf), glyfTransformed },
+    { "loca", 0, len(expectedLoca), nil },
+    { "hmtx", 1, len(expectedHmtx), hmtxTransformed },
+  }, nil))
+  if err != nil {
+    t.Fatal(err)
+  }
+  expected := writeSfnt(0x00010000, []*table{
+    { "head", head }, { "hhea", hhea }, { "maxp", maxp },
+    { "glyf", expectedGlyf }, { "loca", expectedLoca }, { "hmtx", expectedHmtx },
+  })
+  if !bytes.Equal(data, expected) {
+    got, _ := reconstructGlyf(glyfTransformed)
+    t.Errorf("decoded font differs from expected\nglyf: %x\nexpected: %x",
+      got.glyf, expectedGlyf)
+  }
+}
+
+
+func TestDecodeErrors(t *testing.T) {
+  if _, err := Decode([]byte("OTTO\x00\x00")); err != ErrFormat {
+    t.Errorf("Decode(OTTO) = %v ; expected ErrFormat", err)
+  }
+  data := makeWOFF2(0x00010000, []woff2TestTable{
+    { "head", 0, 54, make([]byte, 54) },
+  }, nil)
+  for _, n := range []int{ 20, 50, len(data) - 4 } {
+    if _, err := Decode(data[:n]); err == nil {
+      t.Errorf("expected error for WOFF2 data truncated to %d bytes", n)
+    }
+  }
+
+  // a forged origLength must not cause a huge allocation
+  for _, origLength := range []uint32{ 0xfffffff0, 1 << 20 } {
+    data = makeWOFF(0x00010000, testTables())
+    binary.BigEndian.PutUint32(data[44 + 12:], origLength)
+    if _, err := Decode(data); err == nil {
+      t.Errorf("expected error for origLength %d", origLength)
+    }
+  }
+
+  // a table which decompresses to more than origLength
+  tables := []*table{ { tag: "name", data: make([]byte, 1000) } }
+  data = makeWOFF(0x00010000, tables)
+  binary.BigEndian.PutUint32(data[44 + 12:], 999)
+  if _, err := Decode(data); err == nil {
+    t.Errorf("expected error for table larger than origLength")
+  }
+}
+
+
+// decodeAllocs returns the number of bytes allocated by Decode(data)
+func decodeAllocs(data []byte) (uint64, error) {
+  var before, after runtime.MemStats
+  runtime.ReadMemStats(&before)
+  _, err := Decode(data)
+  runtime.ReadMemStats(&after)
+  return after.TotalAlloc - before.TotalAlloc, err
+}
+
+
+func TestDecodeForgedLengths(t *testing.T) {
+  // small files claiming to decompress to 900 MB are rejected without
+  // allocating anywhere near that much memory
+  const forged = 900 << 20
+  const maxAlloc = 16 << 20
+
+  data := makeWOFF(0x00010000, testTables())
+  binary.BigEndian.PutUint32(data[16:], forged + 1024)  // totalSfntSize
+  binary.BigEndian.PutUint32(data[44 + 12:], forged)    // origLength of name
+  n, err := decodeAllocs(data)
+  if err == nil {
+    t.Errorf("WOFF: expected error for forged origLength")
+  }
+  if n > maxAlloc {
+    t.Errorf("WOFF: %d bytes allocated for a %d byte file", n, len(data))
+  }
+
+  data = makeWOFF2(0x00010000, []woff2TestTable{
+    { "head", 0, 54, make([]byte, 54) },
+    { "name", 0, forged, []byte("name") },
+  }, nil)
+  n, err = decodeAllocs(data)
+  if err == nil {
+    t.Errorf("WOFF2: expected error for forged table length")
+  }
+  if n > maxAlloc {
+    t.Errorf("WOFF2: %d bytes allocated for a %d byte file", n, len(data))
+  }
+}
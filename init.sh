@@ -20,6 +20,12 @@ if [[ "${BASH_SOURCE[0]}" == "${0}" ]]; then
   set -e
   cd "$SRCDIR_REL"
 
+  # make subpackages like client/woff resolve to this source tree
+  mkdir -p "$GOPATH/src/github.com/rsms"
+  if [ ! -e "$GOPATH/src/github.com/rsms/fontctrl" ]; then
+    ln -s "$SRCDIR" "$GOPATH/src/github.com/rsms/fontctrl"
+  fi
+
   pushd client >/dev/null
   echo "client"
   go get -d -v .